@@ -2,9 +2,13 @@ package tftp
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +17,17 @@ const (
 	DatagramSize = 516
 	// 4bytes 헤더를 제외한 데이터그램 크기
 	BlockSize = DatagramSize - 4
+	// blksize 옵션으로 협상할 수 있는 블록 크기 범위 (RFC 2348)
+	MinBlockSize = 8
+	MaxBlockSize = 65464
+)
+
+// 전송 mode
+const (
+	// 줄바꿈을 CR LF로 변환해서 보내는 텍스트 mode
+	ModeNetASCII = "netascii"
+	// 바이너리 그대로 보내는 mode
+	ModeOctet = "octet"
 )
 
 // TFTP의 첫 2bytes = opcode
@@ -22,12 +37,13 @@ type OpCode uint16
 const (
 	// Read ReQuest
 	OpRRQ OpCode = iota + 1
-	// 읽기 전용 서버를 구현할 것이므로
-	// Write ReQuest(WRQ) 지원 x
-	_
+	// Write ReQuest
+	OpWRQ
 	OpData
 	OpAck
 	OpErr
+	// Option ACKnowledgment (RFC 2347)
+	OpOAck
 )
 
 type ErrCode uint16
@@ -43,24 +59,191 @@ const (
 	ErrUnknownID
 	ErrFileExists
 	ErrNoUser
+	// 옵션 협상 실패 (RFC 2347)
+	ErrBadOption
 )
 
+// 패킷을 해석할 수 없을 때 UnmarshalBinary가 리턴하는 에러가 감싸는 에러
+// errors.Is(err, ErrInvalidPacket)로 잘못된 패킷인지 확인한다
+var ErrInvalidPacket = errors.New("invalid packet")
+
+// 어떤 패킷을 왜 해석하거나 만들 수 없는지 알려주는 에러
+type PacketError struct {
+	// 해석하거나 만들려던 패킷의 opcode
+	Op     OpCode
+	Reason string
+}
+
+func (e *PacketError) Error() string {
+	// opcode를 알 수 없는 패킷
+	if e.Op == 0 {
+		return "invalid packet: " + e.Reason
+	}
+
+	return "invalid " + e.Op.String() + ": " + e.Reason
+}
+
+// errors.Is(err, ErrInvalidPacket)로 확인할 수 있도록 감싼 에러 리턴
+func (e *PacketError) Unwrap() error {
+	return ErrInvalidPacket
+}
+
+// op 패킷이 잘못된 이유로 에러 생성
+func invalidPacket(op OpCode, format string, args ...any) error {
+	return &PacketError{Op: op, Reason: fmt.Sprintf(format, args...)}
+}
+
+// 에러 메세지와 로그에 쓸 opcode 이름
+func (o OpCode) String() string {
+	switch o {
+	case OpRRQ:
+		return "RRQ"
+	case OpWRQ:
+		return "WRQ"
+	case OpData:
+		return "DATA"
+	case OpAck:
+		return "ACK"
+	case OpErr:
+		return "ERROR"
+	case OpOAck:
+		return "OACK"
+	default:
+		return "opcode " + strconv.Itoa(int(o))
+	}
+}
+
+// p가 op 패킷으로 시작하는지 확인
+func checkOpcode(p []byte, op OpCode) error {
+	if len(p) < 2 {
+		return invalidPacket(op, "truncated opcode")
+	}
+
+	if code := OpCode(binary.BigEndian.Uint16(p)); code != op {
+		return invalidPacket(op, "unexpected opcode %d", code)
+	}
+
+	return nil
+}
+
+// 모든 TFTP 패킷 타입이 구현하는 인터페이스
+// ParsePacket이 리턴한 패킷은 타입 스위치로 종류를 구분한다
+type Packet interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+	// 패킷의 opcode
+	Op() OpCode
+}
+
+// 첫 2bytes의 opcode를 보고 알맞은 패킷으로 해석
+// *ReadReq, *WriteReq, *Data, *Ack, *Err, *OACK 중 하나를 리턴
+// 협상한 블록 크기를 모르므로 DATA 패킷은 최대 블록 크기까지 받는다
+func ParsePacket(p []byte) (Packet, error) {
+	return parsePacket(p, MaxBlockSize)
+}
+
+// DATA 패킷의 payload를 blockSize까지만 받는 ParsePacket
+func parsePacket(p []byte, blockSize int) (Packet, error) {
+	if len(p) < 2 {
+		return nil, &PacketError{Reason: "truncated opcode"}
+	}
+
+	var pkt Packet
+	switch op := OpCode(binary.BigEndian.Uint16(p)); op {
+	case OpRRQ:
+		pkt = new(ReadReq)
+	case OpWRQ:
+		pkt = new(WriteReq)
+	case OpData:
+		pkt = &Data{Size: blockSize}
+	case OpAck:
+		pkt = new(Ack)
+	case OpErr:
+		pkt = new(Err)
+	case OpOAck:
+		pkt = new(OACK)
+	default:
+		return nil, &PacketError{Reason: fmt.Sprintf("unknown opcode %d", op)}
+	}
+
+	err := pkt.UnmarshalBinary(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return pkt, nil
+}
+
+// 0으로 끝나는 문자열에 쓸 수 없는 0이 들어있는지 확인
+func hasNUL(s string) bool {
+	return strings.IndexByte(s, 0) >= 0
+}
+
 // 읽기 요청 정의
 type ReadReq struct {
 	Filename string
 	// netascii mode - 파일을 line ending format에 맞춰 변환해줘야함
 	// octet mode - 파일을 바이너리 그대로 보냄
 	Mode string
+	// mode 뒤에 붙는 옵션 이름과 값 (RFC 2347)
+	// 옵션 이름은 소문자로 저장
+	Options map[string]string
 }
 
+func (q ReadReq) Op() OpCode { return OpRRQ }
+
 // 핸드셰이크
 func (q ReadReq) MarshalBinary() ([]byte, error) {
+	return marshalRequest(OpRRQ, q.Filename, q.Mode, q.Options)
+}
+
+// 위에서 만든 패킷을 읽을 수 있는 형태로 다시 해석
+func (q *ReadReq) UnmarshalBinary(p []byte) error {
+	var err error
+
+	q.Filename, q.Mode, q.Options, err = unmarshalRequest(p, OpRRQ)
+
+	return err
+}
+
+// 쓰기 요청 정의
+// 패킷 구조는 읽기 요청과 같고 opcode만 다르다
+type WriteReq struct {
+	Filename string
+	Mode     string
+	Options  map[string]string
+}
+
+func (q WriteReq) Op() OpCode { return OpWRQ }
+
+func (q WriteReq) MarshalBinary() ([]byte, error) {
+	return marshalRequest(OpWRQ, q.Filename, q.Mode, q.Options)
+}
+
+func (q *WriteReq) UnmarshalBinary(p []byte) error {
+	var err error
+
+	q.Filename, q.Mode, q.Options, err = unmarshalRequest(p, OpWRQ)
+
+	return err
+}
+
+// 읽기/쓰기 요청 패킷 마샬링
+func marshalRequest(op OpCode, filename, mode string, options map[string]string) ([]byte, error) {
 	// 기본적으로 octet모드 설정 준비
-	mode := "octet"
-	// 요청을 읽었을 때 mode가 정해져 있었다면
-	if q.Mode != "" {
-		// 해당 모드로 mode변수 변경
-		mode = q.Mode
+	// 요청에 mode가 정해져 있었다면 해당 모드 사용
+	if mode == "" {
+		mode = ModeOctet
+	}
+
+	// 0으로 문자열을 구분하므로 0이 들어간 문자열은 보낼 수 없다
+	switch {
+	case filename == "":
+		return nil, invalidPacket(op, "empty filename")
+	case hasNUL(filename):
+		return nil, invalidPacket(op, "filename contains NUL")
+	case hasNUL(mode):
+		return nil, invalidPacket(op, "mode contains NUL")
 	}
 
 	// opcode 2bytes
@@ -68,7 +251,7 @@ func (q ReadReq) MarshalBinary() ([]byte, error) {
 	// 0 1bytes
 	// mode 정보
 	// 0 1bytes
-	cap := 2 + len(q.Filename) + 1 + len(q.Mode) + 1
+	cap := 2 + len(filename) + 1 + len(mode) + 1
 
 	// 버퍼 생성
 	b := new(bytes.Buffer)
@@ -76,16 +259,16 @@ func (q ReadReq) MarshalBinary() ([]byte, error) {
 	b.Grow(cap)
 
 	// 차례대로 버퍼에 써서
-	// 읽기 요청 패킷 구조에 맞게 데이터 쌓기
+	// 요청 패킷 구조에 맞게 데이터 쌓기
 
-	// 먼저 버퍼에 RRQ opcode쓰기
-	err := binary.Write(b, binary.BigEndian, OpRRQ)
+	// 먼저 버퍼에 opcode쓰기
+	err := binary.Write(b, binary.BigEndian, op)
 	if err != nil {
 		return nil, err
 	}
 
-	// 위에서 쓴 RRQ opcode를 q.Filename에 쓰기
-	_, err = b.WriteString(q.Filename)
+	// 파일명 쓰기
+	_, err = b.WriteString(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -108,73 +291,92 @@ func (q ReadReq) MarshalBinary() ([]byte, error) {
 		return nil, err
 	}
 
+	// mode 뒤에 옵션 이름, 0, 값, 0 순서로 쓰기
+	err = writeOptions(b, options)
+	if err != nil {
+		return nil, invalidPacket(op, "%v", err)
+	}
+
 	// 작성한 요청 버퍼 리턴
 	return b.Bytes(), nil
 }
 
-// 위에서 만든 패킷을 읽을 수 있는 형태로 다시 해석
-func (q *ReadReq) UnmarshalBinary(p []byte) error {
-	// p내용을 갖고, p의 길이를 갖는 버퍼 생성
-	r := bytes.NewBuffer(p)
-
-	// uint16 = 2bytes
-	var code OpCode
-
-	// 빅 엔디언으로 code에 opcode(2bytes) 저장
-	err := binary.Read(r, binary.BigEndian, &code)
+// 요청 패킷에서 파일명과 mode 꺼내기
+func unmarshalRequest(p []byte, op OpCode) (filename, mode string, options map[string]string, err error) {
+	// 기대한 opcode로 시작하는지 확인
+	err = checkOpcode(p, op)
 	if err != nil {
-		return nil
+		return "", "", nil, err
 	}
 
-	// code에 OpRRQ가 써지지 않았다면
-	if code != OpRRQ {
-		return errors.New("invalid RRQ")
-	}
+	// opcode 뒤의 데이터를 갖는 버퍼 생성
+	r := bytes.NewBuffer(p[2:])
 
 	// 0을 만날 때까지 r에서 데이터 읽기, 즉 파일명 읽기
-	q.Filename, err = r.ReadString(0)
+	filename, err = r.ReadString(0)
 	if err != nil {
-		return errors.New("invalid RRQ")
+		return "", "", nil, invalidPacket(op, "unterminated filename")
 	}
 
 	// 파일명에 0까지 붙어있으므로 이를 제거
-	q.Filename = strings.TrimRight(q.Filename, "\x00")
-	if len(q.Filename) == 0 {
-		return errors.New("invalid RRQ")
+	filename = strings.TrimSuffix(filename, "\x00")
+	if len(filename) == 0 {
+		return "", "", nil, invalidPacket(op, "empty filename")
 	}
 
 	// 다음 0을 만날 때까지 r에서 데이터 읽어, mode 읽기
-	q.Mode, err = r.ReadString(0)
+	mode, err = r.ReadString(0)
 	if err != nil {
-		return errors.New("invalid RRQ")
+		return "", "", nil, invalidPacket(op, "unterminated mode")
 	}
 
 	// mode명에 0이 붙어있으므로 0을 제거
-	q.Mode = strings.TrimRight(q.Mode, "\x00")
-	if len(q.Mode) == 0 {
-		return errors.New("invalid RRQ")
+	mode = strings.TrimSuffix(mode, "\x00")
+	if len(mode) == 0 {
+		return "", "", nil, invalidPacket(op, "empty mode")
 	}
 
 	// 받은 mode 문자열을 소문자로 변경
-	actual := strings.ToLower(q.Mode)
-	if actual != "octet" {
-		return errors.New("only binary transfers supported")
+	// mode는 대소문자를 구분하지 않으므로 소문자로 통일해서 리턴
+	mode = strings.ToLower(mode)
+	if mode != ModeOctet && mode != ModeNetASCII {
+		return "", "", nil, invalidPacket(op, "unsupported transfer mode %q", mode)
 	}
 
-	return nil
+	// 남은 데이터는 옵션
+	options, err = readOptions(r)
+	if err != nil {
+		return "", "", nil, invalidPacket(op, "%v", err)
+	}
+
+	return filename, mode, options, nil
 }
 
 type Data struct {
 	Block   uint16
 	Payload io.Reader
+	// 블록 하나에 담을 최대 payload 크기
+	// 0이면 기본값인 BlockSize
+	Size int
+}
+
+// 블록 크기, 협상하지 않았다면 512bytes
+func (d *Data) blockSize() int {
+	if d.Size == 0 {
+		return BlockSize
+	}
+
+	return d.Size
 }
 
+func (d *Data) Op() OpCode { return OpData }
+
 // 실제 데이터 교환
 func (d *Data) MarshalBinary() ([]byte, error) {
 	// 버퍼 생성
 	b := new(bytes.Buffer)
 	// 데이터그램만큼 버퍼 크기 증가
-	b.Grow(DatagramSize)
+	b.Grow(4 + d.blockSize())
 
 	// 블록 번호 증가
 	d.Block++
@@ -191,8 +393,8 @@ func (d *Data) MarshalBinary() ([]byte, error) {
 		return nil, err
 	}
 
-	// b에서 d.Payload로 BlockSize bytes만큼 복사
-	_, err = io.CopyN(b, d.Payload, BlockSize)
+	// b에서 d.Payload로 블록 크기만큼 복사
+	_, err = io.CopyN(b, d.Payload, int64(d.blockSize()))
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
@@ -201,22 +403,23 @@ func (d *Data) MarshalBinary() ([]byte, error) {
 }
 
 func (d *Data) UnmarshalBinary(p []byte) error {
-	// 받은 bytes 데이터 길이가 4보다 작거나
-	// Datagram 제한 길이보다 크다면 에러
-	if l := len(p); l < 4 || l > DatagramSize {
-		return errors.New("invalid DATA")
+	// 첫 2bytes가 OpData인지 확인
+	err := checkOpcode(p, OpData)
+	if err != nil {
+		return err
 	}
 
-	var opcode OpCode
-
-	// 첫 2bytes 읽어서 opcode 변수에 저장
-	err := binary.Read(bytes.NewReader(p[:2]), binary.BigEndian, &opcode)
-	if err != nil || opcode != OpData {
-		return errors.New("invalid DATA")
+	// 받은 bytes 데이터 길이가 4보다 작거나
+	// 헤더 + 블록 크기보다 크다면 에러
+	if len(p) < 4 {
+		return invalidPacket(OpData, "truncated block number")
+	}
+	if len(p) > 4+d.blockSize() {
+		return invalidPacket(OpData, "%d bytes payload exceeds block size %d", len(p)-4, d.blockSize())
 	}
 
 	// 블록 번호 읽기
-	err = binary.Read(bytes.NewReader(p[2:4]), binary.BigEndian, &d.Block)
+	d.Block = binary.BigEndian.Uint16(p[2:4])
 
 	// 4bytes부터 payload받아오기
 	d.Payload = bytes.NewBuffer(p[4:])
@@ -227,6 +430,8 @@ func (d *Data) UnmarshalBinary(p []byte) error {
 // 블록 번호
 type Ack uint16
 
+func (a Ack) Op() OpCode { return OpAck }
+
 // 정상적으로 받았다는 확인 데이터(수신 확인 패킷) 마샬링
 func (a Ack) MarshalBinary() ([]byte, error) {
 	// opcode + 블록 번호
@@ -253,23 +458,21 @@ func (a Ack) MarshalBinary() ([]byte, error) {
 }
 
 func (a *Ack) UnmarshalBinary(p []byte) error {
-	var code OpCode
-	// 변수 r에 수신 확인 패킷 p 저장
-	r := bytes.NewReader(p)
-
-	// code 변수에 opcode 저장
-	err := binary.Read(r, binary.BigEndian, &code)
+	// opcode가 OpAck가 아니라면 에러
+	err := checkOpcode(p, OpAck)
 	if err != nil {
 		return err
 	}
 
-	// opcode가 OpAck가 아니라면 에러
-	if code != OpAck {
-		return errors.New("invalid ACK")
+	// opcode + 블록 번호 4bytes여야 한다
+	if len(p) != 4 {
+		return invalidPacket(OpAck, "expected 4 bytes; got %d", len(p))
 	}
 
 	// 블록 번호 읽어서 a에 저장
-	return binary.Read(r, binary.BigEndian, a)
+	*a = Ack(binary.BigEndian.Uint16(p[2:]))
+
+	return nil
 }
 
 type Err struct {
@@ -277,8 +480,15 @@ type Err struct {
 	Message string
 }
 
+func (e Err) Op() OpCode { return OpErr }
+
 // 에러 처리용 패킷 마샬링
 func (e Err) MarshalBinary() ([]byte, error) {
+	// 메세지는 0으로 끝나므로 0이 들어간 메세지는 보낼 수 없다
+	if hasNUL(e.Message) {
+		return nil, invalidPacket(OpErr, "message contains NUL")
+	}
+
 	// opcode + 에러코드 + 메세지 바이트, 구분용 0
 	cap := 2 + 2 + len(e.Message) + 1
 	b := new(bytes.Buffer)
@@ -313,31 +523,161 @@ func (e Err) MarshalBinary() ([]byte, error) {
 }
 
 func (e *Err) UnmarshalBinary(p []byte) error {
-	r := bytes.NewBuffer(p)
+	// opcode가 OpErr가 아니면 에러
+	err := checkOpcode(p, OpErr)
+	if err != nil {
+		return err
+	}
+
+	if len(p) < 4 {
+		return invalidPacket(OpErr, "truncated error code")
+	}
+
+	// 0이 나올 때까지가 메세지
+	// 그 뒤에는 일부 구현이 채우는 0만 올 수 있다
+	msg, rest, ok := bytes.Cut(p[4:], []byte{0})
+	if !ok {
+		return invalidPacket(OpErr, "unterminated message")
+	}
+	if !zeros(rest) {
+		return invalidPacket(OpErr, "data after message")
+	}
 
-	var code OpCode
+	// 에러코드와 메세지 저장
+	e.Error = ErrCode(binary.BigEndian.Uint16(p[2:4]))
+	e.Message = string(msg)
 
-	// opcode code에 저장
-	err := binary.Read(r, binary.BigEndian, &code)
+	return nil
+}
+
+// b가 모두 0인지 확인
+func zeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 서버가 수락한 옵션 (RFC 2347)
+// 옵션 이름과 값
+type OACK map[string]string
+
+func (o OACK) Op() OpCode { return OpOAck }
+
+// 옵션 수락 패킷 마샬링
+func (o OACK) MarshalBinary() ([]byte, error) {
+	b := new(bytes.Buffer)
+
+	// 버퍼에 OpOAck 쓰기
+	err := binary.Write(b, binary.BigEndian, OpOAck)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	// opcode가 OpErr가 아니면 에러
-	if code != OpErr {
-		return errors.New("invalid ERROR")
+	// 옵션 이름, 0, 값, 0 순서로 쓰기
+	err = writeOptions(b, o)
+	if err != nil {
+		return nil, invalidPacket(OpOAck, "%v", err)
 	}
 
-	// 에러코드 e.Error에 저장
-	err = binary.Read(r, binary.BigEndian, &e.Error)
+	return b.Bytes(), nil
+}
+
+func (o *OACK) UnmarshalBinary(p []byte) error {
+	// opcode가 OpOAck가 아니면 에러
+	err := checkOpcode(p, OpOAck)
 	if err != nil {
 		return err
 	}
 
-	// 0이 나올 때까지 읽고
-	e.Message, err = r.ReadString(0)
-	// 오른쪽 0 지우기
-	e.Message = strings.TrimRight(e.Message, "\x00")
+	options, err := readOptions(bytes.NewBuffer(p[2:]))
+	if err != nil {
+		return invalidPacket(OpOAck, "%v", err)
+	}
 
-	return err
+	// 옵션이 하나도 없는 OACK는 의미가 없으므로 에러
+	if len(options) == 0 {
+		return invalidPacket(OpOAck, "no options")
+	}
+
+	*o = options
+
+	return nil
+}
+
+// 옵션을 이름 순서대로 이름, 0, 값, 0 형태로 쓰기
+// 빈 이름은 패킷 끝을 채우는 0과 구분할 수 없으므로 에러
+func writeOptions(b *bytes.Buffer, options map[string]string) error {
+	names := make([]string, 0, len(options))
+	for name, value := range options {
+		switch {
+		case name == "":
+			return errors.New("empty option name")
+		case hasNUL(name):
+			return fmt.Errorf("option name %q contains NUL", name)
+		case hasNUL(value):
+			return fmt.Errorf("option %q value contains NUL", name)
+		}
+
+		names = append(names, name)
+	}
+	// 패킷 내용이 항상 같도록 이름순 정렬
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, s := range []string{name, options[name]} {
+			_, err := b.WriteString(s)
+			if err != nil {
+				return err
+			}
+
+			err = b.WriteByte(0)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 남은 데이터에서 옵션 이름과 값 쌍 읽기
+// 옵션이 없으면 nil 리턴
+func readOptions(r *bytes.Buffer) (map[string]string, error) {
+	var options map[string]string
+
+	for r.Len() > 0 {
+		// 0을 만날 때까지 읽어 옵션 이름 읽기
+		name, err := r.ReadString(0)
+		if err != nil {
+			return nil, errors.New("unterminated option name")
+		}
+
+		// 옵션 이름은 대소문자를 구분하지 않으므로 소문자로 저장
+		name = strings.ToLower(strings.TrimSuffix(name, "\x00"))
+		// 일부 클라이언트는 패킷 끝을 0으로 채우므로 빈 이름에서 멈춤
+		// 채운 0 뒤에 다른 데이터가 있다면 잘못된 패킷
+		if name == "" {
+			if !zeros(r.Bytes()) {
+				return nil, errors.New("data after padding")
+			}
+			break
+		}
+
+		// 다음 0을 만날 때까지 읽어 옵션 값 읽기
+		value, err := r.ReadString(0)
+		if err != nil {
+			return nil, fmt.Errorf("missing value for option %q", name)
+		}
+
+		if options == nil {
+			options = make(map[string]string)
+		}
+		options[name] = strings.TrimSuffix(value, "\x00")
+	}
+
+	return options, nil
 }
@@ -2,47 +2,224 @@
 package tftp
 
 import (
-	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net"
+	"sort"
+	"sync"
 	"time"
 )
 
 // 서버에서 연결 관리를 위해 필요한 데이터 구조체
 type Server struct {
+	// 모든 클라이언트에게 보낼 파일 내용
 	Payload []byte
+	// 모든 클라이언트에게 보낼 내용을 위치로 읽는 io.ReaderAt (*os.File 등)
+	// nil이 아니면 Payload 대신 사용
+	// 전송마다 필요한 블록만 위치로 읽으므로 모든 전송이 열어둔 파일 하나를 같이 쓴다
+	// 서버는 Source를 닫지 않는다
+	Source io.ReaderAt
+	// 요청한 파일명으로 파일을 찾을 파일 시스템
+	// nil이 아니면 Payload 대신 사용
+	FS fs.FS
+	// nil이 아니면 FS에서 읽은 파일 내용을 캐시해서 같은 파일 요청에 다시 쓴다
+	Cache *FileCache
+	// 읽기 요청마다 보낼 내용을 만드는 핸들러
+	// nil이 아니면 FS와 Payload 대신 사용
+	Handler Handler
+	// 쓰기 요청(WRQ)으로 올라온 파일을 저장할 곳
+	// nil이면 쓰기 요청 거부
+	Upload WriterFactory
 	// 재시도 횟수
 	Retries uint8
 	// 연결 종료 시간
 	Timeout time.Duration
+	// true면 측정한 RTT로 재전송 대기 시간을 줄인다
+	// 이때 Timeout은 최대 대기 시간
+	AdaptiveTimeout bool
+	// 블록 번호가 65535를 넘었을 때 돌아갈 번호, 0 또는 1
+	// 클라이언트가 rollover 옵션을 보내면 그 값을 따른다
+	Rollover uint16
+	// 협상할 수 있는 최대 windowsize, 0이면 64
+	MaxWindowSize uint16
+	// 전송마다 새 소켓(TID)을 여는 함수
+	// nil이면 net.ListenPacket 사용
+	ListenPacket func(network, address string) (net.PacketConn, error)
+	// 동시에 진행할 수 있는 최대 전송 수, 0이면 제한 없음
+	// 넘으면 "server busy" 에러 패킷으로 거부
+	MaxTransfers int
+	// 같은 IP에서 동시에 진행할 수 있는 최대 전송 수, 0이면 제한 없음
+	MaxTransfersPerIP int
+	// 요청을 허용할지 정하는 접근 제어, nil이면 모두 허용
+	// 거부한 요청에는 ErrAccessViolation
+	Access AccessControl
+	// 전송 하나의 초당 최대 bytes 수, 0이면 제한 없음
+	TransferRate int64
+	// 모든 전송을 합친 초당 최대 bytes 수, 0이면 제한 없음
+	TotalRate int64
+	// nil이 아니면 전송을 시작할 때와 끝날 때마다 호출
+	// 전송 고루틴에서 바로 호출하므로 오래 걸리는 일은 하지 않아야 한다
+	OnTransfer func(TransferEvent)
+	// nil이 아니면 전송이 끝날 때마다 AuditRecord를 JSON 한 줄로 덧붙인다
+	// 기록을 지우거나 덮어쓰지 않도록 os.O_APPEND로 연 파일을 넘긴다
+	AuditLog io.Writer
+	// multicast 옵션(RFC 2090)을 요청한 읽기 요청에 데이터를 보낼 그룹 주소
+	// nil이면 multicast 옵션을 무시하고 유니캐스트로 보낸다
+	// 같은 파일명을 요청한 클라이언트들을 한 세션으로 묶으므로 Handler를 쓰는 서버도 유니캐스트로 보낸다
+	// 여러 세션이 같은 그룹을 쓰고 클라이언트는 세션의 TID로 구분한다
+	Multicast *net.UDPAddr
+	// 그룹으로 보낼 때 쓸 인터페이스, nil이면 시스템 기본값
+	MulticastInterface *net.Interface
+
+	mu sync.Mutex
+	// Serve 중인 리스너와 그 리스너에서 시작한 전송을 중단시키는 함수
+	listeners map[net.PacketConn]context.CancelFunc
+	// 실행 중인 Serve 호출
+	serving sync.WaitGroup
+	// Shutdown이 호출됐는지 여부
+	shutdown bool
+	// 전송 통계 카운터
+	stats transferStats
+	// 진행 중인 전송 수와 IP별 전송 수
+	active     int
+	activeByIP map[string]int
+	// 진행 중인 전송을 시작한 요청의 키와 그 요청이 다시 오면 호출할 함수
+	inflight map[string]func()
+	// 모든 전송이 같이 쓰는 속도 제한
+	totalRate *rateLimiter
+	// 진행 중인 멀티캐스트 세션, 파일명과 블록 크기별로 하나
+	groups map[string]*mcastSession
+	// AuditLog에 한 번에 한 줄씩 쓰기 위한 락
+	auditMu sync.Mutex
 }
 
-func (s Server) ListenAndServe(addr string) error {
-	// udp 리스너 생성
-	conn, err := net.ListenPacket("udp", addr)
+// Shutdown 이후 Serve와 ListenAndServe가 리턴하는 에러
+var ErrServerClosed = errors.New("tftp: Server closed")
+
+// addrs의 모든 주소에서 동시에 요청 받기
+// IPv6 링크 로컬 주소는 "[fe80::1%eth0]:69"처럼 zone을 붙이고
+// "[::]:69"는 시스템이 허용하면 IPv4와 IPv6 요청을 모두 받는다
+// 모든 리스너가 같은 전송 수 제한, 속도 제한과 통계를 쓴다
+// 리스너 하나가 에러로 끝나면 나머지 리스너도 중단하고 그 에러 리턴
+func (s *Server) ListenAndServe(addrs ...string) error {
+	conns, err := Listen(addrs...)
 	if err != nil {
 		return err
 	}
-	// 리스너 종료
-	defer func() { _ = conn.Close() }()
+	// 리턴할 때 모든 리스너 종료
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	return s.ServeAll(context.Background(), conns...)
+}
+
+// addrs의 모든 주소에 udp 리스너 생성
+// 하나라도 열 수 없다면 이미 연 리스너를 닫고 에러 리턴
+// 69번 포트처럼 권한이 필요한 포트를 먼저 열고 권한을 낮춘 뒤 ServeAll로 요청을 받을 수 있다
+func Listen(addrs ...string) ([]net.PacketConn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("listen address is required")
+	}
 
-	// 리스너 주소 콘솔에 쓰기
-	log.Printf("Listening on %s ...\n", conn.LocalAddr())
+	conns := make([]net.PacketConn, 0, len(addrs))
+	for _, addr := range addrs {
+		// udp 리스너 생성
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+
+		// 리스너 주소 콘솔에 쓰기
+		log.Printf("Listening on %s ...\n", conn.LocalAddr())
+	}
+
+	return conns, nil
+}
+
+// conns의 모든 리스너에서 동시에 Serve
+// 리스너 하나가 에러로 끝나면 나머지 리스너도 중단하고 그 에러 리턴
+// conns는 닫지 않으므로 호출한 쪽에서 닫아야 한다
+func (s *Server) ServeAll(ctx context.Context, conns ...net.PacketConn) error {
+	if len(conns) == 0 {
+		return errors.New("no connections to serve")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errc := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn net.PacketConn) { errc <- s.Serve(ctx, conn) }(conn)
+	}
+
+	// 먼저 끝난 리스너의 에러 리턴
+	// Shutdown이 아니라 에러로 끝났다면 다른 리스너도 중단
+	var first error
+	for range conns {
+		err := <-errc
+		if first == nil {
+			first = err
+			if !errors.Is(err, ErrServerClosed) {
+				cancel()
+			}
+		}
+	}
+
+	return first
+}
+
+// Serve 중인 모든 리스너의 주소
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for conn := range s.listeners {
+		addrs = append(addrs, conn.LocalAddr())
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].String() < addrs[j].String() })
 
-	return s.Serve(conn)
+	return addrs
 }
 
-func (s *Server) Serve(conn net.PacketConn) error {
+// conn으로 요청을 받아 전송마다 고루틴에서 처리
+// ctx가 취소되면 새 요청을 받지 않고 진행 중인 전송을 중단한 뒤 ctx.Err() 리턴
+// Shutdown이 호출되면 진행 중인 전송이 끝나길 기다린 뒤 ErrServerClosed 리턴
+// conn은 닫지 않으므로 호출한 쪽에서 닫아야 한다
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
 	// conn net.PacketConn는 udp 연결
 	// conn == nil은 연결이 없는 경우 에러
 	if conn == nil {
 		return errors.New("nil connection")
 	}
 
-	// 서버에 payload가 없는 경우에도 에러
-	if s.Payload == nil {
-		return errors.New("payload is required")
+	// 서버에 보낼 파일도 업로드 저장소도 없는 경우에도 에러
+	if s.Payload == nil && s.Source == nil && s.FS == nil && s.Handler == nil && s.Upload == nil {
+		return errors.New("payload, source, file system, handler or upload is required")
+	}
+
+	// rollover는 0 또는 1만 가능
+	if s.Rollover > 1 {
+		return errors.New("rollover must be 0 or 1")
+	}
+
+	s.mu.Lock()
+
+	// 이미 Shutdown이 호출된 서버라면 시작하지 않는다
+	if s.shutdown {
+		s.mu.Unlock()
+		return ErrServerClosed
 	}
 
 	// 남은 재시도 횟수가 0이면 10으로 초기화
@@ -55,7 +232,44 @@ func (s *Server) Serve(conn net.PacketConn) error {
 		s.Timeout = 6 * time.Second
 	}
 
-	var rrq ReadReq
+	// 최대 windowsize가 0이면 64로 초기화
+	if s.MaxWindowSize == 0 {
+		s.MaxWindowSize = 64
+	}
+
+	// 모든 리스너가 같은 전체 속도 제한을 쓴다
+	if s.totalRate == nil {
+		s.totalRate = newRateLimiter(s.TotalRate)
+	}
+
+	// Shutdown의 기한이 지나면 이 리스너에서 시작한 전송을 중단할 수 있도록 등록
+	ctx, cancel := context.WithCancel(ctx)
+	if s.listeners == nil {
+		s.listeners = make(map[net.PacketConn]context.CancelFunc)
+	}
+	s.listeners[conn] = cancel
+	s.serving.Add(1)
+
+	s.mu.Unlock()
+
+	// 진행 중인 전송
+	var transfers sync.WaitGroup
+
+	// 리턴하기 전에 진행 중인 전송이 모두 끝나길 기다린다
+	defer func() {
+		transfers.Wait()
+		cancel()
+
+		s.mu.Lock()
+		delete(s.listeners, conn)
+		s.mu.Unlock()
+
+		s.serving.Done()
+	}()
+
+	// ctx가 취소되면 기다리던 ReadFrom을 바로 깨운다
+	stop := wakeOnDone(ctx, conn)
+	defer stop()
 
 	for {
 		// 데이터그램 크기만큼 버퍼 생성
@@ -63,98 +277,263 @@ func (s *Server) Serve(conn net.PacketConn) error {
 
 		// conn에서 데이터 읽어서 buf에 저장
 		// addr에 데이터 송신자 address 저장
-		_, addr, err := conn.ReadFrom(buf)
+		n, addr, err := conn.ReadFrom(buf)
 		if err != nil {
+			// Shutdown이나 ctx 취소로 깨어났다면 새 요청을 더 받지 않는다
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
 			return err
 		}
 
-		// rrq에 버퍼에 적힌 패킷내용 옮기기
-		// opcode, 파일명, mode
-		err = rrq.UnmarshalBinary(buf)
-		if err != nil {
-			log.Printf("[%s] bad request: %v", addr, err)
-			continue
+		// 패킷의 종류에 맞춰 동작하도록 handle메서드 실행
+		// 해석할 수 없는 패킷이면 pkt가 nil이므로 잘못된 요청
+		pkt, _ := ParsePacket(buf[:n])
+		switch req := pkt.(type) {
+		// 읽기 요청, opcode, 파일명, mode
+		case *ReadReq:
+			rrq := *req
+
+			// 진행 중인 전송을 시작한 요청을 다시 보냈다면 무시
+			key := requestKey(addr, OpRRQ, rrq.Filename, rrq.Mode)
+			if s.retried(key) {
+				s.stats.duplicate()
+				break
+			}
+
+			// 접근 제어 규칙에 따라 허용되지 않은 요청이라면 거부
+			if !s.allowed(addr, rrq.Filename) {
+				s.reject(conn, addr, ErrAccessViolation, "access denied")
+				break
+			}
+
+			// 동시 전송 수 제한을 넘었다면 거부
+			if !s.admit(addr, key) {
+				s.reject(conn, addr, ErrUnknown, "server busy")
+				break
+			}
+
+			transfers.Add(1)
+			go func(rrq ReadReq) {
+				defer transfers.Done()
+				defer s.release(addr, key)
+				s.track(OpRRQ, addr, rrq.Filename, rrq.Mode, func(ev *TransferEvent) (int64, error) {
+					return s.handle(ctx, conn.LocalAddr(), addr, rrq, ev)
+				})
+			}(rrq)
+		// 쓰기 요청
+		case *WriteReq:
+			wrq := *req
+
+			key := requestKey(addr, OpWRQ, wrq.Filename, wrq.Mode)
+			if s.retried(key) {
+				s.stats.duplicate()
+				break
+			}
+
+			if !s.allowed(addr, wrq.Filename) {
+				s.reject(conn, addr, ErrAccessViolation, "access denied")
+				break
+			}
+
+			if !s.admit(addr, key) {
+				s.reject(conn, addr, ErrUnknown, "server busy")
+				break
+			}
+
+			transfers.Add(1)
+			go func(wrq WriteReq) {
+				defer transfers.Done()
+				defer s.release(addr, key)
+				s.track(OpWRQ, addr, wrq.Filename, wrq.Mode, func(ev *TransferEvent) (int64, error) {
+					return s.handleWrite(ctx, conn.LocalAddr(), addr, wrq, ev)
+				})
+			}(wrq)
+		default:
+			log.Printf("[%s] bad request", addr)
 		}
+	}
+}
 
-		// 패킷의 종류에 맞춰 동작하도록 handle메서드 실행
-		go s.handle(addr.String(), rrq)
+// 새 요청을 받지 않고 진행 중인 전송이 모두 끝나길 기다린 뒤 nil 리턴
+// 그 전에 ctx가 만료되면 남은 전송을 에러 패킷과 함께 중단하고 ctx.Err() 리턴
+// 어느 경우든 모든 전송 고루틴이 끝난 뒤에 리턴한다
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	// ReadFrom에서 기다리는 Serve를 깨워서 새 요청을 받지 않게 한다
+	for conn := range s.listeners {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// 기한이 지났으므로 진행 중인 전송 중단
+	s.mu.Lock()
+	for _, cancel := range s.listeners {
+		cancel()
+	}
+	s.mu.Unlock()
+
+	<-done
+
+	return ctx.Err()
+}
+
+// 접근 제어 규칙에 따라 요청을 허용하는지 확인
+func (s *Server) allowed(addr net.Addr, filename string) bool {
+	if s.Access == nil {
+		return true
+	}
+
+	return s.Access.Allowed(addrIP(addr), filename)
+}
+
+// 전송을 시작하지 않고 리스너 소켓으로 에러 패킷을 보내 요청 거부
+func (s *Server) reject(conn net.PacketConn, addr net.Addr, code ErrCode, msg string) {
+	log.Printf("[%s] rejected: %s", addr, msg)
+	s.stats.errorSent(code)
+
+	b, err := Err{Error: code, Message: msg}.MarshalBinary()
+	if err != nil {
+		return
 	}
+
+	_, _ = conn.WriteTo(b, addr)
 }
 
-func (s Server) handle(clientAddr string, rrq ReadReq) {
+// Shutdown이 호출됐는지 확인
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.shutdown
+}
+
+// 읽기 요청 처리
+// 보낸 bytes 수와 전송이 실패했다면 그 이유 리턴
+func (s *Server) handle(ctx context.Context, laddr, raddr net.Addr, rrq ReadReq, ev *TransferEvent) (int64, error) {
+	clientAddr := raddr.String()
 	log.Printf("[%s] request file: %s", clientAddr, rrq.Filename)
 
-	// 클라 주소로 udp 연결
-	conn, err := net.Dial("udp", clientAddr)
+	// 이 전송에만 쓸 새 소켓(TID) 생성
+	conn, err := s.listenTransfer(laddr)
 	if err != nil {
-		log.Printf("[%s] dial: %v", clientAddr, err)
+		log.Printf("[%s] listen: %v", clientAddr, err)
+		return 0, err
 	}
-	// 함수 종료시 udp 연결 끊기
+	// 함수 종료시 소켓 닫기
 	defer func() { _ = conn.Close() }()
 
-	var (
-		ackPkt  Ack
-		errPkt  Err
-		dataPkt = Data{Payload: bytes.NewReader(s.Payload)}
-		buf     = make([]byte, DatagramSize)
-	)
-
-NEXTPACKET:
-	// continue에서 반복하다가 보낸 데이터의 크기가 516보다 작아지면 for문 종료
-	for n := DatagramSize; n == DatagramSize; {
-		data, err := dataPkt.MarshalBinary()
-		if err != nil {
-			log.Printf("[%s] preparing data packet: %v", clientAddr, err)
-		}
+	t := &transfer{
+		conn:    conn,
+		peer:    raddr,
+		retries: s.Retries,
+		timeout: s.Timeout,
+		stats:   &s.stats,
+		limits:  []*rateLimiter{newRateLimiter(s.TransferRate), s.totalRate},
+	}
+	// 서버가 종료되면 진행 중인 전송 중단
+	stop := t.watch(ctx)
+	defer stop()
 
-	RETRY:
-		// 재연결 시도 횟수
-		for i := s.Retries; i > 0; i-- {
-			// 클라이언트에 data 보내기
-			// Datagram 크기로 초기화되었던 n을 보낸 data 크기로 업데이트
-			n, err = conn.Write(data)
-			if err != nil {
-				log.Printf("[%s] write: %v", clientAddr, err)
-				return
-			}
+	// 핸들러로 보낼 내용 열기
+	// 핸들러가 에러 패킷을 리턴하면 그대로 클라이언트에게 보낸다
+	src, errPkt := s.handler().ServeTFTP(raddr, rrq)
+	// 보낼 내용도 에러도 리턴하지 않았다면 없는 파일로 취급
+	if errPkt == nil && src == nil {
+		errPkt = &Err{Error: ErrNotFound, Message: "file not found"}
+	}
+	if errPkt != nil {
+		log.Printf("[%s] opening %s: %s", clientAddr, rrq.Filename, errPkt.Message)
+		t.sendErr(errPkt.Error, errPkt.Message)
+		return 0, fmt.Errorf("opening %s: %s", rrq.Filename, errPkt.Message)
+	}
+	// 함수 종료시 파일 닫기
+	defer func() { _ = src.Close() }()
 
-			// 연결에 Timeout 변수만큼 데드라인 설정
-			_ = conn.SetReadDeadline(time.Now().Add(s.Timeout))
+	// netascii mode라면 줄바꿈을 변환하면서 보내기
+	// 변환하면 크기가 달라지므로 tsize는 알 수 없다
+	// 보낸 bytes 수를 세고 감사 로그에 남길 체크섬을 계산하기 위해 src 감싸기
+	h := s.newChecksum()
+	cr := &countingReader{r: src}
+	if h != nil {
+		cr.r = io.TeeReader(src, h)
+	}
 
-			// 클라이언트에 들어온 데이터 버퍼에 복사
-			_, err = conn.Read(buf)
-			if err != nil {
-				if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
-					continue RETRY
-				}
+	var r io.Reader = cr
+	size := sizeOf(src)
+	if rrq.Mode == ModeNetASCII {
+		r = NewNetASCIIReader(cr)
+		size = -1
+	}
 
-				log.Printf("[%s] waiting for ACK: %v", clientAddr, err)
-				return
-			}
+	// 수락한 옵션이 있다면 OACK를 보내고 0번 ACK를 받은 뒤 데이터 전송 시작
+	oack, opts := s.negotiate(clientAddr, OpRRQ, rrq.Options, size)
+	t.setOptions(opts)
+	ev.Options = oack
+	// 클라이언트가 timeout을 정하지 않았다면 측정한 RTT로 재전송 대기 시간 조절
+	if opts.timeout == 0 && s.AdaptiveTimeout {
+		t.rtt = &rttEstimator{max: s.Timeout}
+	}
 
-			switch {
-			// ackPkt에 블록 번호 저장
-			case ackPkt.UnmarshalBinary(buf) == nil:
-				// 읽은 블록번호가 데이터패킷에 들어있는 데이터 번호랑 같다면
-				// NEXTPACKET 계속 실행
-				if uint16(ackPkt) == dataPkt.Block {
-					continue NEXTPACKET
-				}
-			// 에러코드 언마샬링에 성공한 경우
-			// errPkt.Message에 메세지 저장
-			// errPkt.Error에 에러코드 저장
-			case errPkt.UnmarshalBinary(buf) == nil:
-				log.Printf("[%s] received error: %v", clientAddr, errPkt.Message)
-				return
-			default:
-				// 언마샬링 모두 실패시 잘못된 패킷이라고 리턴
-				log.Printf("[%s] bad packet", clientAddr)
-			}
+	// 멀티캐스트로 보낼 수 있다면 같은 파일을 받는 클라이언트들과 함께 받게 한다
+	// 블록 수와 체크섬은 세션이 ev에 채운다
+	if ra, ok := s.multicastSource(clientAddr, rrq, src, opts); ok {
+		return s.serveMulticast(t, rrq, ra, oack, ev)
+	}
+	defer func() { ev.Checksum = checksumString(h) }()
+
+	if len(oack) > 0 {
+		err = t.sendOACK(oack)
+		if err != nil {
+			log.Printf("[%s] %v", clientAddr, err)
+			t.sendAbort()
+			return 0, err
 		}
+	}
 
-		log.Printf("[%s] exhausted retries", clientAddr)
-		return
+	// 파일 내용을 블록 단위로 보내기
+	blocks, err := t.send(r)
+	ev.Blocks = blocks
+	if err != nil {
+		log.Printf("[%s] %v", clientAddr, err)
+		t.sendAbort()
+		return cr.n, err
+	}
+
+	log.Printf("[%s] sent %d blocks", clientAddr, blocks)
+
+	return cr.n, nil
+}
+
+// 전송마다 쓸 새 소켓(TID) 열기
+// 요청을 받은 리스너와 같은 IP의 임의 포트 사용
+func (s *Server) listenTransfer(laddr net.Addr) (net.PacketConn, error) {
+	listen := s.ListenPacket
+	if listen == nil {
+		listen = net.ListenPacket
+	}
+
+	host, _, err := net.SplitHostPort(laddr.String())
+	if err != nil {
+		host = ""
 	}
 
-	log.Printf("[%s] sent %d blocks", clientAddr, dataPkt.Block)
+	return listen(laddr.Network(), net.JoinHostPort(host, "0"))
 }
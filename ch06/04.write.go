@@ -0,0 +1,254 @@
+// TFTP 쓰기 요청(WRQ) 처리 코드
+package tftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// 업로드된 파일명을 받아 내용을 기록할 대상을 만들어주는 함수
+type WriterFactory func(filename string) (io.WriteCloser, error)
+
+// 업로드에 실패했을 때 기록하던 내용을 버릴 수 있는 기록 대상
+// WriterFactory가 리턴한 대상이 구현하면 실패한 전송은 Close 대신 Abort로 끝낸다
+type Aborter interface {
+	Abort() error
+}
+
+// dir 디렉터리 아래에 업로드된 파일을 저장하는 WriterFactory
+// 이미 있는 파일은 덮어쓰지 않는다
+// 임시 파일에 기록하다가 다 받으면 원래 이름으로 옮기므로
+// 실패한 업로드가 반쯤 쓴 파일을 남겨서 다시 올리는 요청을 막는 일이 없다
+func UploadDir(dir string) WriterFactory {
+	return func(filename string) (io.WriteCloser, error) {
+		// 클라이언트가 /로 시작하는 경로를 보내는 경우가 많으므로 제거
+		name := strings.TrimLeft(filename, "/")
+
+		// ../ 등으로 디렉터리 밖에 쓰려는 요청 거부
+		if !filepath.IsLocal(name) {
+			return nil, fmt.Errorf("%q: %w", filename, fs.ErrPermission)
+		}
+
+		// 이미 있는 파일이면 받기 전에 fs.ErrExist 에러
+		path := filepath.Join(dir, name)
+		if _, err := os.Lstat(path); err == nil {
+			return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrExist}
+		}
+
+		// 같은 디렉터리에 만들어야 옮길 때 복사하지 않는다
+		f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+		if err != nil {
+			return nil, err
+		}
+
+		return &uploadFile{File: f, path: path}, nil
+	}
+}
+
+// UploadDir가 받는 중인 임시 파일
+type uploadFile struct {
+	*os.File
+	// 다 받은 뒤의 경로
+	path string
+}
+
+// 임시 파일을 닫고 원래 이름으로 옮기기
+// 그 사이 같은 이름의 업로드가 먼저 끝났다면 덮어쓰지 않도록 rename 대신 link를 쓰고 fs.ErrExist 에러
+func (u *uploadFile) Close() error {
+	tmp := u.Name()
+	defer func() { _ = os.Remove(tmp) }()
+
+	// CreateTemp는 0600으로 만드므로 O_CREATE로 만들던 권한으로 바꾼다
+	err := u.Chmod(0644)
+	if err != nil {
+		_ = u.File.Close()
+		return err
+	}
+
+	// 디스크가 가득 차면 Close에서 에러가 날 수 있다
+	err = u.File.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Link(tmp, u.path)
+}
+
+// 받던 임시 파일 지우기
+func (u *uploadFile) Abort() error {
+	_ = u.File.Close()
+
+	return os.Remove(u.Name())
+}
+
+// 실패한 업로드의 기록 대상 정리
+// Abort할 수 있다면 기록하던 내용을 버리고 아니면 닫기만 한다
+func abortUpload(w io.WriteCloser) {
+	if a, ok := w.(Aborter); ok {
+		_ = a.Abort()
+		return
+	}
+
+	_ = w.Close()
+}
+
+// 파일 시스템 에러를 TFTP 에러코드로 변환
+func errCode(err error) ErrCode {
+	switch {
+	case errors.Is(err, fs.ErrExist):
+		return ErrFileExists
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrAccessViolation
+	case errors.Is(err, syscall.ENOSPC):
+		return ErrDiskFull
+	default:
+		return ErrUnknown
+	}
+}
+
+// 기록하지 못한 err를 상대방에게 보낼 에러코드와 메세지로 변환
+// 파일 시스템 에러에는 기록하던 경로가 들어 있으므로 메세지는 에러코드마다 정해진 문구를 쓴다
+func writeErr(err error) (ErrCode, string) {
+	code := errCode(err)
+
+	switch code {
+	case ErrFileExists:
+		return code, "file already exists"
+	case ErrNotFound:
+		return code, "directory not found"
+	case ErrAccessViolation:
+		return code, "access denied"
+	case ErrDiskFull:
+		return code, "disk full"
+	default:
+		return code, "write failed"
+	}
+}
+
+// 쓰기 요청 처리
+// 받아서 기록한 bytes 수와 전송이 실패했다면 그 이유 리턴
+func (s *Server) handleWrite(ctx context.Context, laddr, raddr net.Addr, wrq WriteReq, ev *TransferEvent) (int64, error) {
+	clientAddr := raddr.String()
+	log.Printf("[%s] write file: %s", clientAddr, wrq.Filename)
+
+	// 이 전송에만 쓸 새 소켓(TID) 생성
+	conn, err := s.listenTransfer(laddr)
+	if err != nil {
+		log.Printf("[%s] listen: %v", clientAddr, err)
+		return 0, err
+	}
+	// 함수 종료시 소켓 닫기
+	defer func() { _ = conn.Close() }()
+
+	t := &transfer{
+		conn:    conn,
+		peer:    raddr,
+		retries: s.Retries,
+		timeout: s.Timeout,
+		stats:   &s.stats,
+		limits:  []*rateLimiter{newRateLimiter(s.TransferRate), s.totalRate},
+	}
+	// 서버가 종료되면 진행 중인 전송 중단
+	stop := t.watch(ctx)
+	defer stop()
+
+	// 업로드 저장소가 없다면 쓰기 요청 거부
+	if s.Upload == nil {
+		t.sendErr(ErrAccessViolation, "write requests not supported")
+		return 0, errors.New("write requests not supported")
+	}
+
+	// 업로드된 내용을 기록할 대상 생성
+	// 이미 있는 파일이면 ErrFileExists, 디스크가 가득 찼다면 ErrDiskFull
+	w, err := s.Upload(wrq.Filename)
+	if err != nil {
+		log.Printf("[%s] opening %s: %v", clientAddr, wrq.Filename, err)
+		t.sendErr(writeErr(err))
+		return 0, err
+	}
+
+	// 수락한 옵션이 있다면 0번 ACK 대신 OACK로 쓰기 요청 수락
+	oack, opts := s.negotiate(clientAddr, OpWRQ, wrq.Options, -1)
+	t.setOptions(opts)
+	ev.Options = oack
+
+	var ack []byte
+	if len(oack) > 0 {
+		ack, err = oack.MarshalBinary()
+	} else {
+		ack, err = Ack(0).MarshalBinary()
+	}
+	if err != nil {
+		abortUpload(w)
+		log.Printf("[%s] preparing ack packet: %v", clientAddr, err)
+		return 0, err
+	}
+
+	// 기록한 bytes 수를 세고 감사 로그에 남길 체크섬을 계산하기 위해 w 감싸기
+	// w에 기록하지 못한 내용은 체크섬에 넣지 않는다
+	h := s.newChecksum()
+	cw := &countingWriter{w: w}
+	if h != nil {
+		cw.w = io.MultiWriter(w, h)
+	}
+	defer func() {
+		ev.Blocks = t.blocks
+		ev.Checksum = checksumString(h)
+	}()
+
+	// netascii mode라면 줄바꿈을 되돌리면서 기록
+	var dst io.Writer = cw
+	if wrq.Mode == ModeNetASCII {
+		dst = NewNetASCIIWriter(cw)
+	}
+
+	// 클라이언트가 보낸 블록을 받아 기록
+	block, err := t.receive(dst, ack, 0)
+	if err != nil {
+		abortUpload(w)
+		log.Printf("[%s] receiving %s: %v", clientAddr, wrq.Filename, err)
+		t.sendAbort()
+		return cw.n, err
+	}
+
+	// 마지막 바이트로 남아있던 CR 기록
+	if nw, ok := dst.(*NetASCIIWriter); ok {
+		err = nw.Flush()
+		if err != nil {
+			abortUpload(w)
+			log.Printf("[%s] writing %s: %v", clientAddr, wrq.Filename, err)
+			t.sendErr(writeErr(err))
+			return cw.n, err
+		}
+	}
+
+	// 디스크가 가득 차면 Close에서 에러가 날 수 있으므로 마지막 ACK 전에 닫기
+	err = w.Close()
+	if err != nil {
+		log.Printf("[%s] closing %s: %v", clientAddr, wrq.Filename, err)
+		t.sendErr(writeErr(err))
+		return cw.n, err
+	}
+
+	// 마지막 블록 ACK
+	err = t.finish(block)
+	if err != nil {
+		log.Printf("[%s] %v", clientAddr, err)
+		return cw.n, err
+	}
+
+	log.Printf("[%s] received %d blocks", clientAddr, block)
+
+	return cw.n, nil
+}
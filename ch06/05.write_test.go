@@ -0,0 +1,363 @@
+// 04 쓰기 요청 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Close가 호출되면 채널로 받은 내용을 넘겨주는 기록 대상
+type chanWriter struct {
+	bytes.Buffer
+	done chan []byte
+}
+
+func (w *chanWriter) Close() error {
+	w.done <- w.Bytes()
+	return nil
+}
+
+func TestWriteReqMarshal(t *testing.T) {
+	wrq := WriteReq{Filename: "firmware.bin", Mode: "octet"}
+
+	b, err := wrq.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 쓰기 요청은 읽기 요청으로 해석되면 안 된다
+	var rrq ReadReq
+	if err = rrq.UnmarshalBinary(b); err == nil {
+		t.Fatal("WRQ accepted as RRQ")
+	}
+
+	var actual WriteReq
+	if err = actual.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(wrq, actual) {
+		t.Errorf("expected %v; actual %v", wrq, actual)
+	}
+}
+
+func TestServerWriteRequest(t *testing.T) {
+	done := make(chan []byte, 1)
+	s := Server{
+		Upload: func(string) (io.WriteCloser, error) {
+			return &chanWriter{done: done}, nil
+		},
+		Timeout: time.Second,
+	}
+
+	// 서버 리스너 생성
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	go func() { _ = s.Serve(context.Background(), conn) }()
+
+	// 클라이언트 리스너 생성
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	// 블록 2개 분량의 업로드할 데이터
+	payload := make([]byte, BlockSize+100)
+	_, _ = rand.Read(payload)
+
+	wrq, err := WriteReq{Filename: "firmware.bin"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.WriteTo(wrq, conn.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ack     Ack
+		buf     = make([]byte, DatagramSize)
+		dataPkt = Data{Payload: bytes.NewReader(payload)}
+	)
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// 0번 ACK가 와야 쓰기 요청이 수락된 것
+	n, server, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ack.UnmarshalBinary(buf[:n]); err != nil || ack != 0 {
+		t.Fatalf("expected ACK 0; actual %v (%v)", ack, err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		data, err := dataPkt.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 이후 패킷은 서버의 새 TID로 보내기
+		_, err = client.WriteTo(data, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, _, err = client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ack.UnmarshalBinary(buf[:n]); err != nil || int(ack) != i {
+			t.Fatalf("expected ACK %d; actual %v (%v)", i, ack, err)
+		}
+	}
+
+	select {
+	case actual := <-done:
+		if !bytes.Equal(payload, actual) {
+			t.Error("uploaded payload differs")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("upload was not closed")
+	}
+}
+
+func TestUploadDirFileExists(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "config.txt"), []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := UploadDir(dir)
+
+	// 이미 있는 파일은 덮어쓰지 않고 ErrFileExists로 변환
+	_, err = upload("config.txt")
+	if code := errCode(err); code != ErrFileExists {
+		t.Errorf("expected error code %d; actual %d (%v)", ErrFileExists, code, err)
+	}
+
+	// 디렉터리 밖으로 나가는 경로는 ErrAccessViolation
+	_, err = upload("../config.txt")
+	if code := errCode(err); code != ErrAccessViolation {
+		t.Errorf("expected error code %d; actual %d (%v)", ErrAccessViolation, code, err)
+	}
+
+	w, err := upload("/new.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	if _, err = os.Stat(filepath.Join(dir, "new.txt")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUploadDirAbort(t *testing.T) {
+	dir := t.TempDir()
+	addr := startServer(t, &Server{Upload: UploadDir(dir), Timeout: time.Second})
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	wrq, err := WriteReq{Filename: "fw.bin"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteTo(wrq, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, DatagramSize)
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, server, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 블록 하나를 보낸 뒤 에러 패킷으로 업로드 중단
+	data, err := (&Data{Payload: bytes.NewReader(make([]byte, BlockSize))}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteTo(data, server); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = client.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	errPkt, err := Err{Error: ErrUnknown, Message: "cancelled"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteTo(errPkt, server); err != nil {
+		t.Fatal(err)
+	}
+
+	// 반쯤 받은 파일은 남기지 않는다
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no files; actual %v", entries)
+		}
+	}
+
+	// 같은 이름으로 다시 올릴 수 있어야 한다
+	_, err = Client{Timeout: time.Second}.Put(context.Background(), addr.String(), "fw.bin", bytes.NewReader([]byte("firmware")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "fw.bin"))
+	if err != nil || string(b) != "firmware" {
+		t.Errorf("expected uploaded file; actual %q (%v)", b, err)
+	}
+}
+
+// Write나 Close에서 err를 리턴하는 기록 대상
+type failingWriter struct {
+	writeErr, closeErr error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	if w.writeErr != nil {
+		return 0, w.writeErr
+	}
+
+	return len(p), nil
+}
+
+func (w failingWriter) Close() error { return w.closeErr }
+
+func TestServerWriteDiskFull(t *testing.T) {
+	full := &fs.PathError{Op: "write", Path: "/srv/tftp/fw.bin", Err: syscall.ENOSPC}
+
+	for _, w := range []failingWriter{{writeErr: full}, {closeErr: full}} {
+		w := w
+		addr := startServer(t, &Server{
+			Upload:  func(string) (io.WriteCloser, error) { return w, nil },
+			Timeout: time.Second,
+		})
+
+		// 디스크가 가득 찼다면 ErrDiskFull, 메세지에 서버의 경로를 넣지 않는다
+		_, err := Client{Timeout: time.Second}.Put(context.Background(), addr.String(), "fw.bin", bytes.NewReader([]byte("firmware")))
+		var pe *peerError
+		if !errors.As(err, &pe) || pe.code != ErrDiskFull || pe.msg != "disk full" {
+			t.Errorf("%+v: expected disk full error; actual %v", w, err)
+		}
+	}
+}
+func TestServerDuplicateWriteRequest(t *testing.T) {
+	dir := t.TempDir()
+	addr := startServer(t, &Server{Upload: UploadDir(dir), Timeout: time.Second})
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	// 0번 ACK가 유실된 클라이언트가 쓰기 요청을 다시 보낸 경우
+	wrq, err := WriteReq{Filename: "fw.bin"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = client.WriteTo(wrq, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 전송 하나만 시작해서 0번 ACK 하나만 오고 ErrFileExists는 오지 않아야 한다
+	var (
+		ack    Ack
+		server net.Addr
+		buf    = make([]byte, DatagramSize)
+	)
+	for {
+		_ = client.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		n, from, err := client.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+
+		if err = ack.UnmarshalBinary(buf[:n]); err != nil || ack != 0 {
+			t.Fatalf("expected ACK 0; actual %v", buf[:n])
+		}
+		if server != nil {
+			t.Fatalf("unexpected second transfer from %s", from)
+		}
+		server = from
+	}
+	if server == nil {
+		t.Fatal("expected ACK 0")
+	}
+
+	// 수락한 전송으로 업로드 마치기
+	data, err := (&Data{Payload: bytes.NewReader([]byte("firmware"))}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteTo(data, server); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ack.UnmarshalBinary(buf[:n]); err != nil || ack != 1 {
+		t.Fatalf("expected ACK 1; actual %v", buf[:n])
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "fw.bin"))
+	if err != nil || string(b) != "firmware" {
+		t.Errorf("expected uploaded file; actual %q (%v)", b, err)
+	}
+
+	// 서버가 마지막 ACK를 보내고 기다리는 동안에도 같은 주소의 새 요청은 받는다
+	wrq, err = WriteReq{Filename: "fw2.bin"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteTo(wrq, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	n, from, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("expected ACK 0 for the new request: %v", err)
+	}
+	if err = ack.UnmarshalBinary(buf[:n]); err != nil || ack != 0 || from.String() == server.String() {
+		t.Fatalf("expected ACK 0 from a new transfer; actual %v from %s", buf[:n], from)
+	}
+}
@@ -0,0 +1,148 @@
+// 요청한 파일명으로 보낼 내용 찾기
+package tftp
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"math"
+	"net"
+	"strings"
+)
+
+// 읽기 요청을 처리할 핸들러
+// Handler가 있으면 Handler, FS가 있으면 FS에서 파일을 찾고
+// 둘 다 없으면 Source나 Payload를 보낸다
+func (s *Server) handler() Handler {
+	switch {
+	case s.Handler != nil:
+		return s.Handler
+	case s.FS != nil && s.Cache != nil:
+		return s.Cache.Handler(s.FS)
+	case s.FS != nil:
+		return FSHandler(s.FS)
+	case s.Source != nil:
+		return ReaderAtHandler(s.Source)
+	default:
+		return PayloadHandler(s.Payload)
+	}
+}
+
+// 모든 요청에 payload를 보내는 핸들러
+// payload가 nil이면 모든 요청에 ErrNotFound
+func PayloadHandler(payload []byte) Handler {
+	// 업로드 전용 서버라 보낼 payload가 없는 경우
+	if payload == nil {
+		return HandlerFunc(func(_ net.Addr, rrq ReadReq) (io.ReadCloser, *Err) {
+			return nil, errPacket(&fs.PathError{Op: "open", Path: rrq.Filename, Err: fs.ErrNotExist})
+		})
+	}
+
+	return ReaderAtHandler(bytes.NewReader(payload))
+}
+
+// 모든 요청에 r의 내용을 처음부터 보내는 핸들러
+// 전송마다 자기 위치에서 r을 읽으므로 여러 전송이 r 하나를 같이 쓸 수 있다
+// r에 Size나 Stat 메서드가 있다면 요청마다 크기를 확인해서 tsize에 쓴다
+// 전송이 끝나도 r은 닫지 않는다
+func ReaderAtHandler(r io.ReaderAt) Handler {
+	return HandlerFunc(func(net.Addr, ReadReq) (io.ReadCloser, *Err) {
+		size := sizeOf(r)
+		if size < 0 {
+			// 크기를 모르면 EOF까지 읽는다
+			return section{io.NewSectionReader(r, 0, math.MaxInt64)}, nil
+		}
+
+		return sizedSection{section{io.NewSectionReader(r, 0, size)}}, nil
+	})
+}
+
+// 요청한 파일명으로 fsys에서 파일을 찾아 보내는 핸들러
+// 없는 파일이면 ErrNotFound, 루트 밖을 가리키면 ErrAccessViolation
+func FSHandler(fsys fs.FS) Handler {
+	return HandlerFunc(func(_ net.Addr, rrq ReadReq) (io.ReadCloser, *Err) {
+		f, err := openFile(fsys, rrq.Filename)
+		if err != nil {
+			return nil, errPacket(err)
+		}
+
+		return f, nil
+	})
+}
+
+// fsys에서 보낼 파일 열기
+func openFile(fsys fs.FS, filename string) (fs.File, error) {
+	// 클라이언트가 /로 시작하는 경로를 보내는 경우가 많으므로 제거
+	name := strings.TrimLeft(filename, "/")
+
+	// ../ 등으로 루트 밖을 가리키는 경로 거부
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrPermission}
+	}
+
+	// 파일을 메모리에 전부 올리지 않고 열어두기만 해서
+	// 블록을 보낼 때마다 필요한 만큼 읽는다
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	// 디렉터리 같은 일반 파일이 아닌 경우는 없는 파일로 취급
+	if !info.Mode().IsRegular() {
+		_ = f.Close()
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+// err에 맞는 에러 코드로 에러 패킷 생성
+func errPacket(err error) *Err {
+	return &Err{Error: errCode(err), Message: err.Error()}
+}
+
+// 위치로 읽는 내용을 처음부터 읽는 io.ReadCloser
+// 블록을 위치로 다시 읽을 수 있도록 ReadAt 메서드도 남겨둔다
+type section struct {
+	r *io.SectionReader
+}
+
+func (s section) Read(p []byte) (int, error) { return s.r.Read(p) }
+
+func (s section) ReadAt(p []byte, off int64) (int, error) { return s.r.ReadAt(p, off) }
+
+func (section) Close() error { return nil }
+
+// 크기를 아는 section
+// Size 메서드가 있어 tsize에 쓸 수 있다
+type sizedSection struct {
+	section
+}
+
+func (s sizedSection) Size() int64 { return s.r.Size() }
+
+// 보낼 내용의 크기, 알 수 없으면 -1
+// io.Reader나 io.ReaderAt 모두 받는다
+func sizeOf(r any) int64 {
+	switch v := r.(type) {
+	// bytes.Reader, strings.Reader 등
+	case interface{ Size() int64 }:
+		return v.Size()
+	// fs.File, os.File 등
+	case interface{ Stat() (fs.FileInfo, error) }:
+		info, err := v.Stat()
+		if err != nil {
+			return -1
+		}
+
+		return info.Size()
+	default:
+		return -1
+	}
+}
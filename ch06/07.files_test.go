@@ -0,0 +1,243 @@
+// 06 파일 시스템에서 파일 찾기 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// 서버를 띄우고 리스너 주소 리턴
+func startServer(t *testing.T, s *Server) net.Addr {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() { _ = s.Serve(context.Background(), conn) }()
+
+	return conn.LocalAddr()
+}
+
+// 서버에 읽기 요청을 보내고 받은 내용 리턴
+// 에러 패킷을 받으면 에러 패킷 리턴
+func download(t *testing.T, server net.Addr, req ReadReq) ([]byte, *Err) {
+	t.Helper()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	rrq, err := req.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.WriteTo(rrq, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		received bytes.Buffer
+		dataPkt  Data
+		errPkt   Err
+		oack     OACK
+		buf      = make([]byte, 4+MaxBlockSize)
+		// 서버가 OACK로 다른 크기를 알려주기 전까지는 기본 블록 크기
+		datagramSize = DatagramSize
+	)
+
+	for {
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		n, addr, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ack Ack
+
+		switch {
+		case errPkt.UnmarshalBinary(buf[:n]) == nil:
+			return nil, &errPkt
+		// 옵션을 수락했다면 협상된 블록 크기를 사용하고 0번 ACK
+		case oack.UnmarshalBinary(buf[:n]) == nil:
+			if v, ok := oack["blksize"]; ok {
+				size, err := strconv.Atoi(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				datagramSize = 4 + size
+				dataPkt.Size = size
+			}
+		default:
+			if err = dataPkt.UnmarshalBinary(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			_, _ = received.ReadFrom(dataPkt.Payload)
+			ack = Ack(dataPkt.Block)
+		}
+
+		b, err := ack.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.WriteTo(b, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 블록 크기보다 작은 블록이 마지막 블록
+		if ack != 0 && n < datagramSize {
+			return received.Bytes(), nil
+		}
+	}
+}
+
+func TestServerFS(t *testing.T) {
+	kernel := bytes.Repeat([]byte("vmlinuz"), 300)
+
+	s := &Server{
+		FS: fstest.MapFS{
+			"boot/vmlinuz":   {Data: kernel},
+			"pxelinux.0":     {Data: []byte("pxe")},
+			"boot/empty.cfg": {Data: []byte{}},
+		},
+		Timeout: time.Second,
+	}
+	addr := startServer(t, s)
+
+	for _, c := range []struct {
+		filename string
+		expected []byte
+	}{
+		{"boot/vmlinuz", kernel},
+		// 앞의 /는 무시
+		{"/pxelinux.0", []byte("pxe")},
+		{"boot/empty.cfg", []byte{}},
+	} {
+		actual, errPkt := download(t, addr, ReadReq{Filename: c.filename})
+		if errPkt != nil {
+			t.Errorf("%s: unexpected error %d: %s", c.filename, errPkt.Error, errPkt.Message)
+			continue
+		}
+
+		if !bytes.Equal(c.expected, actual) {
+			t.Errorf("%s: expected %d bytes; actual %d bytes", c.filename, len(c.expected), len(actual))
+		}
+	}
+
+	for _, c := range []struct {
+		filename string
+		code     ErrCode
+	}{
+		{"missing.bin", ErrNotFound},
+		// 디렉터리는 보낼 수 없다
+		{"boot", ErrNotFound},
+		{"../etc/passwd", ErrAccessViolation},
+		{"boot/../../etc/passwd", ErrAccessViolation},
+	} {
+		_, errPkt := download(t, addr, ReadReq{Filename: c.filename})
+		if errPkt == nil {
+			t.Errorf("%s: expected error %d", c.filename, c.code)
+			continue
+		}
+
+		if errPkt.Error != c.code {
+			t.Errorf("%s: expected error %d; actual %d", c.filename, c.code, errPkt.Error)
+		}
+	}
+}
+
+// Size나 Stat 메서드가 없어 크기를 알 수 없는 io.ReaderAt
+type unsizedReaderAt struct {
+	r io.ReaderAt
+}
+
+func (u unsizedReaderAt) ReadAt(p []byte, off int64) (int, error) { return u.r.ReadAt(p, off) }
+
+func TestServerSource(t *testing.T) {
+	payload := make([]byte, 30*BlockSize+7)
+	_, _ = rand.Read(payload)
+
+	name := filepath.Join(t.TempDir(), "image")
+	if err := os.WriteFile(name, payload, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	addr := startServer(t, &Server{Source: f, Timeout: time.Second})
+
+	// 열어둔 파일 하나를 여러 전송이 동시에 읽는다
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			actual, errPkt := download(t, addr, ReadReq{Filename: "image"})
+			if errPkt != nil {
+				t.Errorf("client %d: unexpected error %d: %s", i, errPkt.Error, errPkt.Message)
+				return
+			}
+
+			if !bytes.Equal(payload, actual) {
+				t.Errorf("client %d: expected %d bytes; actual %d bytes", i, len(payload), len(actual))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 파일 크기를 tsize로 알려준다
+	oack := requestOACK(t, addr, ReadReq{Filename: "image", Options: map[string]string{"tsize": "0"}})
+	if expected := strconv.Itoa(len(payload)); oack["tsize"] != expected {
+		t.Errorf("expected tsize %s; actual %q", expected, oack["tsize"])
+	}
+
+	// 서버는 Source를 닫지 않는다
+	if _, err := f.ReadAt(make([]byte, 1), 0); err != nil {
+		t.Errorf("source closed: %v", err)
+	}
+}
+
+func TestServerSourceUnknownSize(t *testing.T) {
+	payload := make([]byte, 5*BlockSize)
+	_, _ = rand.Read(payload)
+
+	addr := startServer(t, &Server{Source: unsizedReaderAt{bytes.NewReader(payload)}, Timeout: time.Second})
+
+	actual, errPkt := download(t, addr, ReadReq{Filename: "image"})
+	if errPkt != nil {
+		t.Fatalf("unexpected error %d: %s", errPkt.Error, errPkt.Message)
+	}
+	if !bytes.Equal(payload, actual) {
+		t.Errorf("expected %d bytes; actual %d bytes", len(payload), len(actual))
+	}
+
+	// 크기를 모르면 tsize를 수락하지 않는다
+	oack := requestOACK(t, addr, ReadReq{Filename: "image", Options: map[string]string{"tsize": "0", "blksize": "1024"}})
+	if v, ok := oack["tsize"]; ok {
+		t.Errorf("expected no tsize; actual %q", v)
+	}
+}
@@ -0,0 +1,126 @@
+// TFTP 옵션 협상 (RFC 2347)
+package tftp
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// 옵션 협상으로 정해지는 전송마다의 설정
+type transferOptions struct {
+	// 블록 하나의 payload 크기 (blksize)
+	blockSize int
+	// ACK 없이 연달아 보낼 블록 수 (windowsize)
+	windowSize int
+	// 클라이언트가 정한 재전송 대기 시간 (timeout), 0이면 서버 설정 사용
+	timeout time.Duration
+	// 전송할 파일 크기 (tsize), 모르면 -1
+	size int64
+	// 블록 번호가 65535를 넘었을 때 돌아갈 번호 (rollover), 0 또는 1
+	rollover uint16
+	// 클라이언트가 멀티캐스트로 받기를 원하는지 여부 (multicast)
+	multicast bool
+}
+
+// 클라이언트가 요청한 옵션 중 서버가 수락한 옵션만 골라 OACK로 만들기
+// 수락한 옵션이 없으면 옵션 없는 기존 전송으로 진행
+// op는 요청 종류(OpRRQ, OpWRQ), size는 읽기 요청으로 보낼 파일 크기로 모르면 -1
+func (s *Server) negotiate(clientAddr string, op OpCode, options map[string]string, size int64) (OACK, transferOptions) {
+	oack := make(OACK)
+	// 협상하지 않은 설정은 RFC 1350 기본값 사용
+	opts := transferOptions{
+		blockSize:  BlockSize,
+		windowSize: 1,
+		size:       size,
+		rollover:   s.Rollover,
+	}
+
+	for name, value := range options {
+		switch name {
+		// 블록 크기 옵션 (RFC 2348)
+		case "blksize":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < MinBlockSize {
+				log.Printf("[%s] ignoring invalid blksize %q", clientAddr, value)
+				continue
+			}
+
+			// 최대 크기보다 크게 요청했다면 서버가 줄여서 응답
+			if size > MaxBlockSize {
+				size = MaxBlockSize
+			}
+
+			opts.blockSize = size
+			oack[name] = strconv.Itoa(size)
+		// 윈도우 크기 옵션 (RFC 7440)
+		case "windowsize":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 1 || size > 65535 {
+				log.Printf("[%s] ignoring invalid windowsize %q", clientAddr, value)
+				continue
+			}
+
+			// 서버의 최대 윈도우보다 크게 요청했다면 줄여서 응답
+			if max := int(s.MaxWindowSize); size > max {
+				size = max
+			}
+
+			opts.windowSize = size
+			oack[name] = strconv.Itoa(size)
+		// 재전송 대기 시간 옵션 (RFC 2349), 1 ~ 255초
+		case "timeout":
+			sec, err := strconv.Atoi(value)
+			if err != nil || sec < 1 || sec > 255 {
+				log.Printf("[%s] ignoring invalid timeout %q", clientAddr, value)
+				continue
+			}
+
+			opts.timeout = time.Duration(sec) * time.Second
+			oack[name] = strconv.Itoa(sec)
+		// 파일 크기 옵션 (RFC 2349)
+		case "tsize":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				log.Printf("[%s] ignoring invalid tsize %q", clientAddr, value)
+				continue
+			}
+
+			// 쓰기 요청이면 클라이언트가 알려준 크기를 그대로 돌려주고
+			// 읽기 요청이면 0 대신 실제 파일 크기를 알려준다
+			if op == OpWRQ {
+				opts.size = n
+			} else if size < 0 {
+				// 크기를 모르는 파일이면 옵션 무시
+				continue
+			}
+
+			oack[name] = strconv.FormatInt(opts.size, 10)
+		// 블록 번호 rollover 옵션
+		// RFC는 없지만 큰 파일을 받는 클라이언트들이 쓰는 옵션
+		case "rollover":
+			if value != "0" && value != "1" {
+				log.Printf("[%s] ignoring invalid rollover %q", clientAddr, value)
+				continue
+			}
+
+			opts.rollover = uint16(value[0] - '0')
+			oack[name] = value
+		// 멀티캐스트 옵션 (RFC 2090)
+		// 그룹 주소는 세션을 정한 뒤에 채우므로 여기서는 요청 여부만 기록
+		// 세션은 파일명으로만 묶으므로 클라이언트마다 다른 내용을 보낼 수 있는 Handler가 있다면 거절
+		case "multicast":
+			if op != OpRRQ || s.Multicast == nil || s.Handler != nil {
+				log.Printf("[%s] ignoring unsupported option %q", clientAddr, name)
+				continue
+			}
+
+			opts.multicast = true
+		// 서버가 모르는 옵션은 OACK에 넣지 않고 무시
+		default:
+			log.Printf("[%s] ignoring unsupported option %q", clientAddr, name)
+		}
+	}
+
+	return oack, opts
+}
@@ -0,0 +1,221 @@
+// 08 옵션 협상 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadReqOptions(t *testing.T) {
+	rrq := ReadReq{
+		Filename: "pxelinux.0",
+		Mode:     "octet",
+		Options:  map[string]string{"blksize": "1428", "tsize": "0"},
+	}
+
+	b, err := rrq.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual ReadReq
+	if err = actual.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(rrq, actual) {
+		t.Errorf("expected %v; actual %v", rrq, actual)
+	}
+
+	// 옵션 이름은 대소문자를 구분하지 않는다
+	b = append([]byte{0, byte(OpRRQ)}, "file\x00octet\x00BlkSize\x001024\x00"...)
+	if err = actual.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if v := actual.Options["blksize"]; v != "1024" {
+		t.Errorf("expected blksize 1024; actual %q", v)
+	}
+
+	// 값이 없는 옵션은 잘못된 요청
+	b = append([]byte{0, byte(OpRRQ)}, "file\x00octet\x00blksize"...)
+	if err = actual.UnmarshalBinary(b); err == nil {
+		t.Error("expected error for truncated option")
+	}
+}
+
+func TestOACK(t *testing.T) {
+	oack := OACK{"blksize": "1428", "tsize": "1048576"}
+
+	b, err := oack.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual OACK
+	if err = actual.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(oack, actual) {
+		t.Errorf("expected %v; actual %v", oack, actual)
+	}
+
+	// OACK는 ACK나 DATA로 해석되면 안 된다
+	var ack Ack
+	if err = ack.UnmarshalBinary(b); err == nil {
+		t.Error("OACK accepted as ACK")
+	}
+}
+
+func TestServerIgnoresUnknownOptions(t *testing.T) {
+	addr := startServer(t, &Server{Payload: []byte("payload"), Timeout: time.Second})
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	rrq, err := ReadReq{
+		Filename: "payload",
+		Options:  map[string]string{"x-unknown": "1"},
+	}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.WriteTo(rrq, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, DatagramSize)
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 수락한 옵션이 없으므로 OACK 없이 바로 1번 블록이 와야 한다
+	var dataPkt Data
+	if err = dataPkt.UnmarshalBinary(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if dataPkt.Block != 1 {
+		t.Errorf("expected block 1; actual %d", dataPkt.Block)
+	}
+}
+
+func TestDataBlockSize(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, 3000)
+	dataPkt := Data{Payload: bytes.NewReader(payload), Size: 1428}
+
+	b, err := dataPkt.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 헤더 4bytes + 협상된 블록 크기
+	if l := len(b); l != 4+1428 {
+		t.Fatalf("expected %d bytes; actual %d bytes", 4+1428, l)
+	}
+
+	// 기본 블록 크기로는 해석할 수 없다
+	var actual Data
+	if err = actual.UnmarshalBinary(b); err == nil {
+		t.Error("oversized DATA accepted with default block size")
+	}
+
+	actual.Size = 1428
+	if err = actual.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerBlockSize(t *testing.T) {
+	payload := make([]byte, 100000)
+	_, _ = rand.Read(payload)
+
+	addr := startServer(t, &Server{Payload: payload, Timeout: time.Second})
+
+	for _, blksize := range []string{
+		"1428",
+		// 블록 크기로 나누어 떨어지는 경우 빈 블록이 마지막 블록
+		"1000",
+		// 최대 크기보다 크면 서버가 줄여서 응답
+		"100000",
+	} {
+		actual, errPkt := download(t, addr, ReadReq{
+			Filename: "payload",
+			Options:  map[string]string{"blksize": blksize},
+		})
+		if errPkt != nil {
+			t.Fatalf("blksize %s: unexpected error %d: %s", blksize, errPkt.Error, errPkt.Message)
+		}
+
+		if !bytes.Equal(payload, actual) {
+			t.Errorf("blksize %s: expected %d bytes; actual %d bytes", blksize, len(payload), len(actual))
+		}
+	}
+}
+
+func TestServerOversizedData(t *testing.T) {
+	dir := t.TempDir()
+	addr := startServer(t, &Server{Upload: UploadDir(dir), Timeout: time.Second})
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	wrq, err := WriteReq{Filename: "fw.bin", Options: map[string]string{"blksize": "1024"}}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteTo(wrq, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, DatagramSize)
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, server, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var oack OACK
+	if err = oack.UnmarshalBinary(buf[:n]); err != nil || oack["blksize"] != "1024" {
+		t.Fatalf("expected OACK with blksize 1024; actual %v", buf[:n])
+	}
+
+	// 협상한 블록 크기보다 1byte 큰 블록은 잘라서 받지 않고 거부
+	data := append([]byte{0, byte(OpData), 0, 1}, bytes.Repeat([]byte{'x'}, 1025)...)
+	if _, err = client.WriteTo(data, server); err != nil {
+		t.Fatal(err)
+	}
+
+	errPkt := expectErrPacket(t, client)
+	if errPkt.Error != ErrIllegalOp {
+		t.Errorf("expected error %d; actual %d: %s", ErrIllegalOp, errPkt.Error, errPkt.Message)
+	}
+
+	// 업로드하던 파일은 남기지 않는다
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no files; actual %v", entries)
+		}
+	}
+}
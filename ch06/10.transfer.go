@@ -0,0 +1,576 @@
+// 서버와 클라이언트가 같이 쓰는 블록 송수신 코드
+package tftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"time"
+)
+
+// 하나의 전송(읽기/쓰기)에 필요한 소켓과 설정
+type transfer struct {
+	// 이 전송 전용 소켓, 로컬 포트가 나의 TID
+	conn net.PacketConn
+	// 상대방 주소, 상대방 포트가 상대의 TID
+	peer net.Addr
+	// 재시도 횟수
+	retries uint8
+	// 응답을 기다리는 시간
+	timeout time.Duration
+	// 협상된 옵션
+	opts transferOptions
+	// nil이 아니면 측정한 RTT로 재전송 대기 시간 계산
+	rtt *rttEstimator
+	// 취소되면 전송 중단, nil이면 중단하지 않는다
+	ctx context.Context
+	// 중복 패킷과 재전송 수를 셀 카운터, nil이면 세지 않는다
+	stats *transferStats
+	// 데이터를 주고받을 때 지킬 속도 제한들
+	limits []*rateLimiter
+	// receive로 순서대로 받은 블록 수
+	blocks int
+}
+
+// 전송이 ctx 취소로 중단됐을 때 read가 리턴하는 에러
+var errAborted = errors.New("transfer aborted")
+
+// 응답을 기다리다 재시도 횟수를 다 썼을 때의 에러
+var errExhausted = errors.New("exhausted retries")
+
+// 상대방이 보낸 에러 패킷으로 전송이 중단됐을 때의 에러
+// 감사 로그에 남길 수 있도록 상대방이 보낸 에러 코드를 담는다
+type peerError struct {
+	code ErrCode
+	msg  string
+}
+
+func (e *peerError) Error() string {
+	return "received error: " + e.msg
+}
+
+// ctx가 취소되면 전송을 중단하도록 설정
+// 리턴하는 함수로 감시를 멈춘다
+func (t *transfer) watch(ctx context.Context) func() {
+	t.ctx = ctx
+
+	return wakeOnDone(ctx, t.conn)
+}
+
+// ctx 취소로 전송이 중단됐는지 확인
+func (t *transfer) aborted() bool {
+	return t.ctx != nil && t.ctx.Err() != nil
+}
+
+// 전송이 중단됐다면 상대방에게 에러 패킷으로 알리기
+func (t *transfer) sendAbort() {
+	if t.aborted() {
+		t.sendErr(ErrUnknown, "transfer aborted")
+	}
+}
+
+// ctx가 취소되면 읽기 데드라인을 지금으로 바꿔서 conn.ReadFrom에서 기다리는 쪽을 깨운다
+// 깨어난 쪽은 ctx.Err()로 취소됐는지 확인해야 한다
+// 리턴하는 함수로 감시를 멈춘다
+func wakeOnDone(ctx context.Context, conn net.PacketConn) func() {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// 협상된 옵션 적용
+// 클라이언트가 timeout을 정했다면 그 값으로 기다린다
+func (t *transfer) setOptions(opts transferOptions) {
+	t.opts = opts
+	if opts.timeout > 0 {
+		t.timeout = opts.timeout
+	}
+}
+
+// 응답을 기다릴 시간
+func (t *transfer) wait() time.Duration {
+	if t.rtt == nil {
+		return t.timeout
+	}
+
+	return t.rtt.timeout()
+}
+
+// 윈도우 안의 보낸 블록
+type windowBlock struct {
+	block uint16
+	data  []byte
+}
+
+// block 다음 블록 번호
+// 65535 다음은 rollover 설정에 따라 0 또는 1
+func (t *transfer) next(block uint16) uint16 {
+	if block == math.MaxUint16 {
+		return t.opts.rollover
+	}
+
+	return block + 1
+}
+
+// 블록 번호 from에서 to까지 next로 몇 번 넘어가야 하는지
+// rollover가 1이면 0번을 건너뛰므로 번호가 돌아갔다면 하나 적다
+func (t *transfer) distance(from, to uint16) uint16 {
+	d := to - from
+	if to < from && t.opts.rollover == 1 {
+		d--
+	}
+
+	return d
+}
+
+// 상대방에게 패킷 보내기
+func (t *transfer) write(b []byte) error {
+	_, err := t.conn.WriteTo(b, t.peer)
+
+	return err
+}
+
+// 상대방이 보낸 패킷 읽기
+// 다른 TID에서 온 패킷은 ErrUnknownID로 응답하고
+// 데드라인은 그대로 둔 채 계속 기다린다
+func (t *transfer) read(buf []byte) (int, error) {
+	for {
+		// 중단된 전송이라면 데드라인이 다시 설정됐더라도 기다리지 않는다
+		if t.aborted() {
+			return 0, errAborted
+		}
+
+		n, addr, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			if t.aborted() {
+				return 0, errAborted
+			}
+
+			return 0, err
+		}
+
+		if sameTID(addr, t.peer) {
+			return n, nil
+		}
+
+		t.rejectTID(addr, buf[:n])
+	}
+}
+
+// 두 주소가 같은 TID(같은 IP와 포트)인지 확인
+func sameTID(a, b net.Addr) bool {
+	ua, ok := a.(*net.UDPAddr)
+	if !ok {
+		return a.String() == b.String()
+	}
+
+	ub, ok := b.(*net.UDPAddr)
+	if !ok {
+		return a.String() == b.String()
+	}
+
+	return ua.Port == ub.Port && ua.IP.Equal(ub.IP) && ua.Zone == ub.Zone
+}
+
+// 알 수 없는 TID에서 온 패킷에 ErrUnknownID로 응답 (RFC 1350)
+// 에러 패킷에 다시 에러 패킷으로 응답하지는 않는다
+func (t *transfer) rejectTID(addr net.Addr, p []byte) {
+	log.Printf("[%s] packet from unknown TID %s", t.peer, addr)
+
+	pkt, _ := ParsePacket(p)
+	if _, ok := pkt.(*Err); ok {
+		return
+	}
+
+	b, err := Err{Error: ErrUnknownID, Message: "unknown transfer ID"}.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	_, _ = t.conn.WriteTo(b, addr)
+}
+
+// 상대방에게 에러 패킷 보내기
+func (t *transfer) sendErr(code ErrCode, msg string) {
+	t.stats.errorSent(code)
+
+	b, err := Err{Error: code, Message: msg}.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	_ = t.write(b)
+}
+
+// 타임아웃 에러인지 확인
+func isTimeout(err error) bool {
+	var nErr net.Error
+
+	return errors.As(err, &nErr) && nErr.Timeout()
+}
+
+// 읽기 요청에 대해 OACK를 보내고 클라이언트의 0번 ACK 기다리기
+func (t *transfer) sendOACK(oack OACK) error {
+	data, err := oack.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("preparing oack packet: %w", err)
+	}
+
+	buf := make([]byte, DatagramSize)
+
+RETRY:
+	for i := t.retries; i > 0; i-- {
+		if i != t.retries {
+			t.stats.retransmit(1)
+		}
+
+		err = t.write(data)
+		if err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+
+		_ = t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+
+		// 다시 보내는 건 타임아웃일 때만
+		for {
+			n, err := t.read(buf)
+			if err != nil {
+				if isTimeout(err) {
+					t.stats.timeout()
+					continue RETRY
+				}
+
+				return fmt.Errorf("waiting for ACK: %w", err)
+			}
+
+			pkt, _ := ParsePacket(buf[:n])
+			switch pkt := pkt.(type) {
+			case *Ack:
+				if *pkt == 0 {
+					return nil
+				}
+				t.stats.duplicate()
+			// 클라이언트가 옵션을 거부하면 ErrBadOption 에러 패킷이 온다
+			case *Err:
+				return &peerError{code: pkt.Error, msg: pkt.Message}
+			default:
+				log.Printf("[%s] bad packet", t.peer)
+			}
+		}
+	}
+
+	return errExhausted
+}
+
+// r의 내용을 블록 단위로 상대방에게 보내고 보낸 블록 수 리턴
+// windowsize만큼 블록을 연달아 보낸 뒤 ACK를 기다린다 (RFC 7440)
+func (t *transfer) send(r io.Reader) (int, error) {
+	var (
+		dataPkt = Data{Payload: r, Size: t.opts.blockSize}
+		buf     = make([]byte, DatagramSize)
+		// 헤더를 포함한 협상된 데이터그램 크기
+		datagramSize = 4 + t.opts.blockSize
+		// 보냈지만 아직 ACK 받지 못한 블록들
+		// 타임아웃이면 마지막으로 ACK 받은 블록 다음부터 다시 보낸다
+		window []windowBlock
+		// 마지막 블록을 만들었는지 여부
+		last   bool
+		blocks int
+	)
+
+NEXTWINDOW:
+	for {
+		// 윈도우가 빌 때마다 다음 블록들로 채우기
+		for len(window) < t.opts.windowSize && !last {
+			// MarshalBinary가 블록 번호를 1 늘리므로
+			// 65535 다음 번호가 1이어야 한다면 미리 0으로 돌려두기
+			if dataPkt.Block == math.MaxUint16 && t.opts.rollover == 1 {
+				dataPkt.Block = 0
+			}
+
+			data, err := dataPkt.MarshalBinary()
+			if err != nil {
+				return blocks, fmt.Errorf("preparing data packet: %w", err)
+			}
+
+			window = append(window, windowBlock{block: dataPkt.Block, data: data})
+			blocks++
+
+			// 보낸 데이터의 크기가 데이터그램 크기보다 작으면 마지막 블록
+			last = len(data) < datagramSize
+		}
+
+		// 마지막 블록까지 모두 ACK 받았다면 전송 완료
+		if len(window) == 0 {
+			return blocks, nil
+		}
+
+	RETRY:
+		// 재연결 시도 횟수
+		for i := t.retries; i > 0; i-- {
+			sent := time.Now()
+
+			// 처음 보내는 윈도우가 아니라면 타임아웃으로 다시 보내는 것
+			if i != t.retries {
+				t.stats.retransmit(len(window))
+			}
+
+			// 윈도우에 있는 블록을 ACK 기다리지 않고 연달아 보내기
+			for _, b := range window {
+				// 속도 제한이 있다면 보낼 수 있을 때까지 기다리기
+				err := t.throttle(len(b.data))
+				if err != nil {
+					return blocks, err
+				}
+
+				err = t.write(b.data)
+				if err != nil {
+					return blocks, fmt.Errorf("write: %w", err)
+				}
+			}
+
+			// 연결에 대기 시간만큼 데드라인 설정
+			// 윈도우 밖의 ACK를 받아도 데드라인은 늘리지 않는다
+			_ = t.conn.SetReadDeadline(time.Now().Add(t.wait()))
+
+			for {
+				// 상대방이 보낸 데이터 버퍼에 복사
+				n, err := t.read(buf)
+				if err != nil {
+					if isTimeout(err) {
+						t.stats.timeout()
+						// 타임아웃이면 대기 시간을 늘려서 다시 보내기
+						if t.rtt != nil {
+							t.rtt.backoff()
+						}
+						continue RETRY
+					}
+
+					return blocks, fmt.Errorf("waiting for ACK: %w", err)
+				}
+
+				pkt, _ := ParsePacket(buf[:n])
+				switch pkt := pkt.(type) {
+				// 받은 ACK의 블록 번호
+				case *Ack:
+					// ACK는 해당 블록까지 모두 받았다는 의미이므로
+					// 윈도우 안의 블록이라면 그 블록까지 윈도우에서 빼고 다음 윈도우 전송
+					// 블록 번호가 65535를 넘어 돌아가도 윈도우 안에서는 번호가 겹치지 않는다
+					for k, b := range window {
+						if b.block != uint16(*pkt) {
+							continue
+						}
+
+						// 다시 보낸 윈도우의 ACK는 어느 전송에 대한 것인지 모르므로
+						// 처음 보낸 윈도우의 ACK만 RTT 측정에 사용 (Karn 알고리즘)
+						if t.rtt != nil && i == t.retries {
+							t.rtt.sample(time.Since(sent))
+						}
+
+						window = window[k+1:]
+						continue NEXTWINDOW
+					}
+
+					// 윈도우 밖의 ACK는 이미 처리한 블록에 대한 늦거나 중복된 ACK
+					// 여기에 응답해서 다시 보내면 이후 모든 블록이 두 번씩 오가게 되므로
+					// (Sorcerer's Apprentice 문제, RFC 1123 4.2.3.1) 무시하고 계속 기다린다
+					t.stats.duplicate()
+				// 에러 패킷이라면 중단
+				case *Err:
+					return blocks, &peerError{code: pkt.Error, msg: pkt.Message}
+				default:
+					// 언마샬링 모두 실패시 잘못된 패킷
+					log.Printf("[%s] bad packet", t.peer)
+				}
+			}
+		}
+
+		return blocks, errExhausted
+	}
+}
+
+// 상대방이 보낸 블록을 w에 기록하고 마지막으로 받은 블록 번호 리턴
+// ack는 처음 보낼 패킷으로, 0번 ACK나 OACK
+// start는 이미 받은 마지막 블록 번호로, 처음부터 받는다면 0
+// windowsize만큼 블록을 받을 때마다 ACK를 보낸다 (RFC 7440)
+// 마지막 블록에 대한 ACK는 호출한 쪽에서 finish로 보낸다
+func (t *transfer) receive(w io.Writer, ack []byte, start uint16) (uint16, error) {
+	var (
+		// 마지막으로 순서대로 받은 블록 번호
+		ackPkt = Ack(start)
+		// 헤더를 포함한 협상된 데이터그램 크기
+		datagramSize = 4 + t.opts.blockSize
+		// 더 큰 데이터그램이 잘려서 온전한 블록처럼 보이지 않도록 1byte 크게 받는다
+		buf = make([]byte, datagramSize+1)
+		// 남은 재시도 횟수
+		tries = t.retries
+		// 순서가 어긋난 블록 때문에 ACK를 다시 보냈는지 여부
+		// 중간 블록이 유실되어 건너뛴 윈도우 안의 블록마다 ACK를 보내지 않도록 한 번만 보낸다
+		reacked bool
+	)
+
+	for {
+		// 다음 블록들 요청
+		err := t.write(ack)
+		if err != nil {
+			return 0, fmt.Errorf("write: %w", err)
+		}
+
+		// 이번 ACK 이후로 순서대로 받은 블록 수
+		received := 0
+
+		// 연결에 timeout만큼 데드라인 설정
+		// 무시하는 중복 블록을 받아도 데드라인은 늘리지 않는다
+		// 다시 보낸 ACK가 유실됐다면 상대방이 계속 다시 보내는 블록 때문에
+		// 타임아웃 없이 서로 기다리기만 하게 되므로 타임아웃으로 다시 ACK
+		_ = t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+
+	READ:
+		for {
+			n, err := t.read(buf)
+			if err != nil {
+				if isTimeout(err) {
+					t.stats.timeout()
+					// 재시도 횟수를 다 썼다면 포기
+					if tries--; tries == 0 {
+						return 0, errExhausted
+					}
+
+					// 마지막으로 받은 블록까지 다시 ACK
+					t.stats.retransmit(1)
+					break READ
+				}
+
+				return 0, fmt.Errorf("waiting for DATA: %w", err)
+			}
+
+			// 협상한 블록 크기보다 큰 DATA 패킷은 잘못된 패킷
+			pkt, err := parsePacket(buf[:n], t.opts.blockSize)
+			switch pkt := pkt.(type) {
+			case *Data:
+				// 기다리던 다음 블록이 아니라면 중간 블록이 유실됐거나
+				// 나의 ACK가 유실되어 다시 온 블록이므로
+				// 마지막으로 순서대로 받은 블록까지 ACK
+				if pkt.Block != t.next(uint16(ackPkt)) {
+					t.stats.duplicate()
+
+					// 이미 받은 블록이 다시 왔다면 다시 보낸 ACK도 유실되어 상대방이 재전송한 것이므로
+					// 한 번만 보내고 기다리면 상대방이 먼저 재시도 횟수를 다 쓸 수 있어 다시 ACK
+					old := t.distance(pkt.Block, uint16(ackPkt)) < uint16(t.opts.windowSize)
+					if reacked && !old {
+						continue READ
+					}
+
+					reacked = true
+					break READ
+				}
+
+				// 기다리던 블록이라면 기록
+				// 기록에 실패하면 상대방에게 알리고 중단
+				// 디스크가 가득 찼다면 ErrDiskFull
+				_, err = io.Copy(w, pkt.Payload)
+				if err != nil {
+					t.sendErr(writeErr(err))
+					return 0, err
+				}
+
+				// 속도 제한이 있다면 ACK를 늦춰서 상대방이 천천히 보내게 한다
+				err = t.throttle(n)
+				if err != nil {
+					return 0, err
+				}
+
+				ackPkt = Ack(pkt.Block)
+				received++
+				t.blocks++
+				tries = t.retries
+				reacked = false
+				_ = t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+
+				// 블록 크기보다 작은 블록이 마지막 블록
+				if n < datagramSize {
+					return uint16(ackPkt), nil
+				}
+
+				// 윈도우만큼 받았다면 ACK
+				if received == t.opts.windowSize {
+					break READ
+				}
+			case *Err:
+				return 0, &peerError{code: pkt.Error, msg: pkt.Message}
+			default:
+				// 협상을 어긴 DATA 패킷이라면 상대방에게 알리고 중단
+				var pe *PacketError
+				if errors.As(err, &pe) && pe.Op == OpData {
+					t.sendErr(ErrIllegalOp, err.Error())
+					return 0, err
+				}
+
+				log.Printf("[%s] bad packet", t.peer)
+			}
+		}
+
+		// 아직 블록을 하나도 받지 못했다면 처음 패킷(OACK)을 다시 보내고
+		// 받은 블록이 있다면 마지막으로 순서대로 받은 블록까지 ACK
+		if t.blocks > 0 {
+			ack, err = ackPkt.MarshalBinary()
+			if err != nil {
+				return 0, fmt.Errorf("preparing ack packet: %w", err)
+			}
+		}
+	}
+}
+
+// 블록에 대한 ACK 보내기
+func (t *transfer) ack(block uint16) error {
+	ack, err := Ack(block).MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("preparing ack packet: %w", err)
+	}
+
+	err = t.write(ack)
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+// 마지막 블록에 대한 ACK 보내기
+// 마지막 ACK가 유실되면 상대방이 마지막 블록을 다시 보내므로
+// timeout 동안 기다리며 다시 온 마지막 블록에 ACK
+// 다시 ACK한 뒤에도 그 ACK가 유실될 수 있으므로 기다리는 시간을 다시 시작한다
+func (t *transfer) finish(block uint16) error {
+	err := t.ack(block)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+t.opts.blockSize)
+
+	_ = t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+	for {
+		n, err := t.read(buf)
+		if err != nil {
+			return nil
+		}
+
+		pkt, _ := parsePacket(buf[:n], t.opts.blockSize)
+		if dataPkt, ok := pkt.(*Data); ok && dataPkt.Block == block {
+			_ = t.ack(block)
+			_ = t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// 10 windowsize 전송 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"tftp/lossy"
+	"time"
+)
+
+// 메모리 내 네트워크에서 서버를 띄우고 리스너 주소 리턴
+func startMemServer(t *testing.T, n *lossy.Network, s *Server) net.Addr {
+	t.Helper()
+
+	conn, err := n.ListenPacket("udp", "127.0.0.1:69")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	s.ListenPacket = n.ListenPacket
+	go func() { _ = s.Serve(context.Background(), conn) }()
+
+	return conn.LocalAddr()
+}
+
+// 메모리 내 네트워크에서 옵션을 붙인 읽기 요청으로 파일 받기
+func memDownload(t *testing.T, n *lossy.Network, server net.Addr, rrq ReadReq, timeout time.Duration) []byte {
+	t.Helper()
+
+	c := Client{
+		Retries:      50,
+		Timeout:      timeout,
+		Options:      rrq.Options,
+		ListenPacket: n.ListenPacket,
+	}
+
+	var received bytes.Buffer
+	_, err := c.Get(context.Background(), server.String(), rrq.Filename, &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return received.Bytes()
+}
+
+// 메모리 내 네트워크에서 옵션을 붙인 쓰기 요청으로 파일 올리기
+func memUpload(t *testing.T, n *lossy.Network, server net.Addr, wrq WriteReq, payload []byte, timeout time.Duration) {
+	t.Helper()
+
+	c := Client{
+		Retries:      50,
+		Timeout:      timeout,
+		Options:      wrq.Options,
+		ListenPacket: n.ListenPacket,
+	}
+
+	_, err := c.Put(context.Background(), server.String(), wrq.Filename, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerWindowSize(t *testing.T) {
+	payload := make([]byte, 200*BlockSize+100)
+	_, _ = rand.Read(payload)
+
+	n := lossy.NewNetwork(lossy.Config{Seed: 1})
+	addr := startMemServer(t, n, &Server{Payload: payload, Timeout: 50 * time.Millisecond})
+
+	actual := memDownload(t, n, addr, ReadReq{
+		Filename: "payload",
+		Options:  map[string]string{"windowsize": "8"},
+	}, 50*time.Millisecond)
+
+	if !bytes.Equal(payload, actual) {
+		t.Fatalf("expected %d bytes; actual %d bytes", len(payload), len(actual))
+	}
+
+	// 유실이 없다면 8블록마다 ACK 하나만 오가야 한다
+	// 데이터 201블록 + ACK 26개 + RRQ, OACK, 0번 ACK
+	sent := n.Stats().Sent
+	if max := 201 + 26 + 3; sent > max {
+		t.Errorf("expected at most %d packets; actual %d", max, sent)
+	}
+}
+
+func TestServerWindowSizeLossy(t *testing.T) {
+	payload := make([]byte, 200*BlockSize)
+	_, _ = rand.Read(payload)
+
+	// 10% 확률로 패킷 유실
+	n := lossy.NewNetwork(lossy.Config{Loss: 0.1, Seed: 1})
+	addr := startMemServer(t, n, &Server{
+		Payload: payload,
+		Retries: 50,
+		Timeout: 20 * time.Millisecond,
+	})
+
+	for _, window := range []string{"1", "4", "16"} {
+		actual := memDownload(t, n, addr, ReadReq{
+			Filename: "payload",
+			Options:  map[string]string{"windowsize": window, "blksize": "1024"},
+		}, 20*time.Millisecond)
+
+		if !bytes.Equal(payload, actual) {
+			t.Errorf("windowsize %s: expected %d bytes; actual %d bytes", window, len(payload), len(actual))
+		}
+	}
+
+	if n.Stats().Dropped == 0 {
+		t.Error("expected dropped packets")
+	}
+}
+
+func TestServerWriteWindowSize(t *testing.T) {
+	payload := make([]byte, 50*BlockSize+10)
+	_, _ = rand.Read(payload)
+
+	done := make(chan []byte, 1)
+	n := lossy.NewNetwork(lossy.Config{Loss: 0.1, Seed: 2})
+	addr := startMemServer(t, n, &Server{
+		Upload: func(string) (io.WriteCloser, error) {
+			return &chanWriter{done: done}, nil
+		},
+		Retries: 50,
+		Timeout: 20 * time.Millisecond,
+	})
+
+	memUpload(t, n, addr, WriteReq{
+		Filename: "upload",
+		Options:  map[string]string{"windowsize": "4"},
+	}, payload, 20*time.Millisecond)
+
+	select {
+	case actual := <-done:
+		if !bytes.Equal(payload, actual) {
+			t.Errorf("expected %d bytes; actual %d bytes", len(payload), len(actual))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("upload was not closed")
+	}
+}
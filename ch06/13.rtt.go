@@ -0,0 +1,64 @@
+// 재전송 대기 시간 계산 (RFC 6298)
+package tftp
+
+import "time"
+
+// 재전송 대기 시간의 최소값
+// 측정한 RTT가 아무리 짧아도 이보다 빨리 재전송하지 않는다
+const minRTO = 10 * time.Millisecond
+
+// 측정한 왕복 시간(RTT)으로 재전송 대기 시간(RTO) 계산
+type rttEstimator struct {
+	// 재전송 대기 시간의 최대값, 서버의 Timeout
+	max time.Duration
+	// 평활화된 RTT와 RTT 편차
+	srtt, rttvar time.Duration
+	// 현재 재전송 대기 시간, 0이면 아직 측정값 없음
+	rto time.Duration
+}
+
+// 새로 측정한 RTT 반영
+func (e *rttEstimator) sample(r time.Duration) {
+	if e.rto == 0 {
+		// 첫 측정값
+		e.srtt = r
+		e.rttvar = r / 2
+	} else {
+		// RTTVAR = 3/4 RTTVAR + 1/4 |SRTT - R|
+		diff := e.srtt - r
+		if diff < 0 {
+			diff = -diff
+		}
+		e.rttvar = (3*e.rttvar + diff) / 4
+		// SRTT = 7/8 SRTT + 1/8 R
+		e.srtt = (7*e.srtt + r) / 8
+	}
+
+	// RTO = SRTT + 4 RTTVAR
+	e.rto = e.srtt + 4*e.rttvar
+}
+
+// 타임아웃이 나면 대기 시간을 두 배로 늘리기
+func (e *rttEstimator) backoff() {
+	if e.rto == 0 {
+		return
+	}
+
+	e.rto *= 2
+	if e.rto > e.max {
+		e.rto = e.max
+	}
+}
+
+// 응답을 기다릴 시간
+// 측정값이 없으면 최대값을 기다린다
+func (e *rttEstimator) timeout() time.Duration {
+	switch {
+	case e.rto == 0 || e.rto > e.max:
+		return e.max
+	case e.rto < minRTO:
+		return minRTO
+	default:
+		return e.rto
+	}
+}
@@ -0,0 +1,169 @@
+// 13 재전송 대기 시간 계산과 tsize, timeout 옵션 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"tftp/lossy"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	e := rttEstimator{max: time.Second}
+
+	// 측정값이 없으면 최대값을 기다린다
+	if actual := e.timeout(); actual != time.Second {
+		t.Errorf("expected %s; actual %s", time.Second, actual)
+	}
+
+	for i := 0; i < 10; i++ {
+		e.sample(100 * time.Millisecond)
+	}
+
+	// RTT가 일정하면 편차가 줄어들어 RTT에 가까워진다
+	if actual := e.timeout(); actual < 100*time.Millisecond || actual > 200*time.Millisecond {
+		t.Errorf("expected timeout close to 100ms; actual %s", actual)
+	}
+
+	// 타임아웃마다 두 배로 늘어나지만 최대값을 넘지 않는다
+	before := e.timeout()
+	e.backoff()
+	if actual := e.timeout(); actual != 2*before {
+		t.Errorf("expected %s; actual %s", 2*before, actual)
+	}
+	for i := 0; i < 10; i++ {
+		e.backoff()
+	}
+	if actual := e.timeout(); actual != time.Second {
+		t.Errorf("expected %s; actual %s", time.Second, actual)
+	}
+
+	// 아주 빠른 링크라도 최소값보다 짧게 기다리지 않는다
+	e = rttEstimator{max: time.Second}
+	e.sample(time.Microsecond)
+	if actual := e.timeout(); actual != minRTO {
+		t.Errorf("expected %s; actual %s", minRTO, actual)
+	}
+}
+
+// 읽기 요청을 보내고 서버가 보낸 OACK 리턴
+func requestOACK(t *testing.T, server net.Addr, rrq ReadReq) OACK {
+	t.Helper()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	req, err := rrq.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.WriteTo(req, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, DatagramSize)
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	n, addr, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var oack OACK
+	if err = oack.UnmarshalBinary(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+
+	// 서버가 계속 기다리지 않도록 전송 취소
+	b, _ := Err{Error: ErrBadOption, Message: "test"}.MarshalBinary()
+	_, _ = client.WriteTo(b, addr)
+
+	return oack
+}
+
+func TestServerTsizeAndTimeout(t *testing.T) {
+	payload := bytes.Repeat([]byte{'p'}, 1234)
+	kernel := bytes.Repeat([]byte{'k'}, 4321)
+
+	payloadAddr := startServer(t, &Server{Payload: payload, Timeout: time.Second})
+	fsAddr := startServer(t, &Server{
+		FS:      fstest.MapFS{"vmlinuz": {Data: kernel}},
+		Timeout: time.Second,
+	})
+
+	for _, c := range []struct {
+		addr     net.Addr
+		expected int
+	}{
+		{payloadAddr, len(payload)},
+		{fsAddr, len(kernel)},
+	} {
+		oack := requestOACK(t, c.addr, ReadReq{
+			Filename: "vmlinuz",
+			Options:  map[string]string{"tsize": "0", "timeout": "3"},
+		})
+
+		// 읽기 요청의 tsize에는 실제 파일 크기를 돌려준다
+		if actual := oack["tsize"]; actual != strconv.Itoa(c.expected) {
+			t.Errorf("expected tsize %d; actual %q", c.expected, actual)
+		}
+
+		if actual := oack["timeout"]; actual != "3" {
+			t.Errorf("expected timeout 3; actual %q", actual)
+		}
+	}
+
+	// 범위를 벗어난 timeout은 무시
+	oack := requestOACK(t, payloadAddr, ReadReq{
+		Filename: "payload",
+		Options:  map[string]string{"tsize": "0", "timeout": "0"},
+	})
+	if _, ok := oack["timeout"]; ok {
+		t.Errorf("expected invalid timeout to be ignored; actual %v", oack)
+	}
+}
+
+func TestServerAdaptiveTimeout(t *testing.T) {
+	payload := make([]byte, 100*BlockSize)
+	_, _ = rand.Read(payload)
+
+	// 5% 확률로 패킷 유실
+	n := lossy.NewNetwork(lossy.Config{Loss: 0.05, Seed: 3})
+	// 고정된 대기 시간이면 유실마다 3초를 기다려야 한다
+	addr := startMemServer(t, n, &Server{
+		Payload:         payload,
+		Timeout:         3 * time.Second,
+		AdaptiveTimeout: true,
+	})
+
+	start := time.Now()
+	actual := memDownload(t, n, addr, ReadReq{
+		Filename: "payload",
+		Options:  map[string]string{"tsize": "0"},
+	}, 200*time.Millisecond)
+	elapsed := time.Since(start)
+
+	if !bytes.Equal(payload, actual) {
+		t.Fatalf("expected %d bytes; actual %d bytes", len(payload), len(actual))
+	}
+
+	dropped := n.Stats().Dropped
+	if dropped == 0 {
+		t.Fatal("expected dropped packets")
+	}
+
+	// 측정한 RTT로 재전송하므로 유실된 블록마다 Timeout이나
+	// 클라이언트의 재전송 요청을 기다리지 않는다
+	if elapsed > 1500*time.Millisecond {
+		t.Errorf("transfer with %d dropped packets took %s", dropped, elapsed)
+	}
+}
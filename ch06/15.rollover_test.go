@@ -0,0 +1,211 @@
+// 블록 번호 rollover 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"tftp/lossy"
+	"time"
+)
+
+// 65536블록보다 많은 블록이 나오도록 8bytes 블록으로 나눌 데이터
+func rolloverPayload() []byte {
+	payload := make([]byte, 70000*8+3)
+	_, _ = rand.Read(payload)
+
+	return payload
+}
+
+// 메모리 내 네트워크에서 보내는 쪽과 받는 쪽을 직접 연결하여 전송
+func memTransfer(t *testing.T, payload []byte, sendRollover, recvRollover uint16) ([]byte, error) {
+	t.Helper()
+
+	n := lossy.NewNetwork(lossy.Config{Seed: 1})
+
+	a, err := n.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = a.Close() }()
+
+	b, err := n.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Close() }()
+
+	sender := &transfer{
+		conn: a, peer: b.LocalAddr(), retries: 3, timeout: 100 * time.Millisecond,
+		opts: transferOptions{blockSize: 8, windowSize: 64, rollover: sendRollover},
+	}
+	receiver := &transfer{
+		conn: b, peer: a.LocalAddr(), retries: 3, timeout: 100 * time.Millisecond,
+		opts: transferOptions{blockSize: 8, windowSize: 64, rollover: recvRollover},
+	}
+
+	go func() {
+		// 받는 쪽의 0번 ACK를 받은 뒤 전송 시작
+		buf := make([]byte, DatagramSize)
+		if _, _, err := a.ReadFrom(buf); err != nil {
+			return
+		}
+
+		_, _ = sender.send(bytes.NewReader(payload))
+	}()
+
+	ack, err := Ack(0).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received bytes.Buffer
+	_, err = receiver.receive(&received, ack, 0)
+
+	return received.Bytes(), err
+}
+
+func TestTransferRollover(t *testing.T) {
+	payload := rolloverPayload()
+
+	for _, rollover := range []uint16{0, 1} {
+		actual, err := memTransfer(t, payload, rollover, rollover)
+		if err != nil {
+			t.Fatalf("rollover %d: %v", rollover, err)
+		}
+
+		if !bytes.Equal(payload, actual) {
+			t.Errorf("rollover %d: expected %d bytes; actual %d bytes", rollover, len(payload), len(actual))
+		}
+	}
+
+	// 양쪽의 rollover 설정이 다르면 65535번 다음 블록을 받을 수 없다
+	actual, err := memTransfer(t, payload, 1, 0)
+	if err == nil {
+		t.Fatal("expected rollover mismatch to fail")
+	}
+	if l := len(actual); l != 65535*8 {
+		t.Errorf("expected transfer to stop after block 65535; actual %d bytes", l)
+	}
+}
+
+func TestServerRollover(t *testing.T) {
+	payload := rolloverPayload()
+
+	done := make(chan []byte, 1)
+	n := lossy.NewNetwork(lossy.Config{Seed: 1})
+	addr := startMemServer(t, n, &Server{
+		Payload: payload,
+		Upload: func(string) (io.WriteCloser, error) {
+			return &chanWriter{done: done}, nil
+		},
+		Timeout: 100 * time.Millisecond,
+	})
+
+	for _, options := range []map[string]string{
+		// 클라이언트가 rollover 옵션을 보내지 않으면 서버 설정(0) 사용
+		{"blksize": "8", "windowsize": "64"},
+		{"blksize": "8", "windowsize": "64", "rollover": "1"},
+	} {
+		actual := memDownload(t, n, addr, ReadReq{Filename: "payload", Options: options}, 100*time.Millisecond)
+
+		if !bytes.Equal(payload, actual) {
+			t.Errorf("%v: expected %d bytes; actual %d bytes", options, len(payload), len(actual))
+		}
+
+		memUpload(t, n, addr, WriteReq{Filename: "upload", Options: options}, payload, 100*time.Millisecond)
+
+		select {
+		case actual = <-done:
+			if !bytes.Equal(payload, actual) {
+				t.Errorf("%v: expected %d bytes uploaded; actual %d bytes", options, len(payload), len(actual))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("upload was not closed")
+		}
+	}
+}
+
+func TestReceiveStaleBlockAcrossRollover(t *testing.T) {
+	// 65535번은 두 설정 모두 마지막으로 받은 블록보다 2블록 앞이므로
+	// windowsize 3의 윈도우 안에서 다시 온 블록
+	for _, c := range []struct {
+		rollover, start uint16
+	}{
+		{0, 1},
+		{1, 2},
+	} {
+		n := lossy.NewNetwork(lossy.Config{Seed: 1})
+
+		a, err := n.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := n.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		receiver := &transfer{
+			conn: b, peer: a.LocalAddr(), retries: 3, timeout: time.Second,
+			opts: transferOptions{blockSize: 8, windowSize: 3, rollover: c.rollover},
+		}
+		ack, err := Ack(c.start).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := receiver.receive(io.Discard, ack, c.start)
+			done <- err
+		}()
+
+		// 윈도우 안의 이미 받은 블록이 두 번 오면 ACK도 두 번 다시 보낸다
+		stale, err := (&Data{Block: 65534, Payload: bytes.NewReader(make([]byte, 8)), Size: 8}).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err = a.WriteTo(stale, b.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		acks := 0
+		buf := make([]byte, DatagramSize)
+		for acks < 3 {
+			_ = a.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			m, _, err := a.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+
+			var actual Ack
+			if err = actual.UnmarshalBinary(buf[:m]); err != nil || uint16(actual) != c.start {
+				t.Fatalf("rollover %d: expected ACK %d; actual %v", c.rollover, c.start, buf[:m])
+			}
+			acks++
+		}
+		// 처음 보낸 ACK와 다시 온 블록마다 보낸 ACK
+		if acks != 3 {
+			t.Errorf("rollover %d: expected 3 ACKs for block %d; actual %d", c.rollover, c.start, acks)
+		}
+
+		// 마지막 블록을 보내서 끝내기
+		last, err := (&Data{Block: c.start, Payload: bytes.NewReader([]byte("end")), Size: 8}).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = a.WriteTo(last, b.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if err = <-done; err != nil {
+			t.Errorf("rollover %d: %v", c.rollover, err)
+		}
+
+		_ = a.Close()
+		_ = b.Close()
+	}
+}
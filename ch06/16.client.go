@@ -0,0 +1,431 @@
+// TFTP 클라이언트 코드
+package tftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 서버에서 파일을 받거나 서버로 파일을 올리는 클라이언트
+type Client struct {
+	// 재시도 횟수, 0이면 10
+	Retries uint8
+	// 응답을 기다리는 시간, 0이면 6초
+	Timeout time.Duration
+	// 전송 mode, 비어있으면 octet
+	// netascii라면 줄바꿈을 변환하며 주고받는다
+	Mode string
+	// 요청에 붙일 옵션 (blksize, windowsize, tsize 등)
+	// Put에서 tsize를 보내면 실제 크기를 알 수 있는 경우 그 값으로 바꿔 보낸다
+	Options map[string]string
+	// 서버가 rollover 옵션을 수락하지 않았을 때 사용할 값, 0 또는 1
+	Rollover uint16
+	// 전송에 쓸 소켓을 여는 함수
+	// nil이면 net.ListenPacket 사용
+	ListenPacket func(network, address string) (net.PacketConn, error)
+	// Options에 multicast 옵션이 있을 때 멀티캐스트 그룹에 참여할 인터페이스
+	// nil이면 시스템 기본값
+	MulticastInterface *net.Interface
+}
+
+// 서버 addr에서 filename 파일을 받아 w에 기록하고 받은 bytes 수 리턴
+func (c Client) Get(ctx context.Context, addr, filename string, w io.Writer) (int64, error) {
+	req, err := ReadReq{Filename: filename, Mode: c.Mode, Options: c.Options}.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	t, stop, err := c.dial(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer stop()
+
+	// 받은 bytes 수를 세기 위해 w 감싸기
+	cw := &countingWriter{w: w}
+
+	// netascii mode라면 줄바꿈을 되돌리면서 기록
+	var (
+		dst io.Writer = cw
+		nw  *NetASCIIWriter
+	)
+	if strings.EqualFold(c.Mode, ModeNetASCII) {
+		nw = NewNetASCIIWriter(cw)
+		dst = nw
+	}
+
+	// 요청을 보내고 서버의 첫 응답 기다리기
+	pkt, err := c.request(t, req)
+	if err != nil {
+		return 0, c.ctxErr(t, err)
+	}
+
+	var (
+		ack []byte
+		// 이미 받은 마지막 블록 번호
+		start uint16
+	)
+
+	reply, _ := parsePacket(pkt, t.opts.blockSize)
+	switch reply := reply.(type) {
+	// 서버가 옵션을 수락했다면 협상된 설정을 적용하고 0번 ACK로 전송 시작
+	case *OACK:
+		// 옵션을 요청하지 않았는데 온 OACK
+		if len(c.Options) == 0 {
+			return 0, unexpectedPacket(pkt)
+		}
+
+		err = c.applyOACK(t, *reply)
+		if err != nil {
+			t.sendErr(ErrBadOption, err.Error())
+			return 0, err
+		}
+
+		// 서버가 multicast 옵션을 수락했다면 그룹에 참여해서 받기 (RFC 2090)
+		if value, ok := (*reply)["multicast"]; ok {
+			err = c.receiveMulticast(t, value, dst)
+			if err != nil {
+				t.sendAbort()
+				return cw.n, c.ctxErr(t, err)
+			}
+
+			return cw.n, nil
+		}
+
+		ack, err = Ack(0).MarshalBinary()
+		if err != nil {
+			return 0, err
+		}
+	// 옵션 없이 바로 1번 블록이 왔다면 기록하고 1번 ACK부터 시작
+	case *Data:
+		if reply.Block != 1 {
+			return 0, unexpectedPacket(pkt)
+		}
+
+		_, err = io.Copy(dst, reply.Payload)
+		if err != nil {
+			t.sendErr(writeErr(err))
+			return cw.n, err
+		}
+
+		// 첫 블록이 마지막 블록이라면 ACK만 보내고 종료
+		if len(pkt) < 4+t.opts.blockSize {
+			return cw.n, c.finish(t, nw, 1)
+		}
+
+		start = 1
+		ack, err = Ack(start).MarshalBinary()
+		if err != nil {
+			return cw.n, err
+		}
+	default:
+		return 0, unexpectedPacket(pkt)
+	}
+
+	// 나머지 블록 받기
+	block, err := t.receive(dst, ack, start)
+	if err != nil {
+		// 전송 중에 취소됐다면 서버에 알리기
+		t.sendAbort()
+		return cw.n, c.ctxErr(t, err)
+	}
+
+	return cw.n, c.finish(t, nw, block)
+}
+
+// 남은 netascii 데이터를 기록하고 마지막 블록 ACK
+func (c Client) finish(t *transfer, nw *NetASCIIWriter, block uint16) error {
+	if nw != nil {
+		err := nw.Flush()
+		if err != nil {
+			t.sendErr(writeErr(err))
+			return err
+		}
+	}
+
+	return t.ack(block)
+}
+
+// r의 내용을 서버 addr에 filename으로 올리고 보낸 bytes 수 리턴
+func (c Client) Put(ctx context.Context, addr, filename string, r io.Reader) (int64, error) {
+	options := c.Options
+
+	netascii := strings.EqualFold(c.Mode, ModeNetASCII)
+
+	// tsize를 요청했다면 실제 크기로 바꿔서 보내기
+	// netascii mode는 변환 후 크기를 미리 알 수 없으므로 그대로 보낸다
+	if _, ok := options["tsize"]; ok && !netascii {
+		if size := sizeOf(r); size >= 0 {
+			options = make(map[string]string, len(c.Options))
+			for name, value := range c.Options {
+				options[name] = value
+			}
+			options["tsize"] = strconv.FormatInt(size, 10)
+		}
+	}
+
+	req, err := WriteReq{Filename: filename, Mode: c.Mode, Options: options}.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	t, stop, err := c.dial(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer stop()
+
+	// 요청을 보내고 서버의 첫 응답 기다리기
+	pkt, err := c.request(t, req)
+	if err != nil {
+		return 0, c.ctxErr(t, err)
+	}
+
+	reply, _ := ParsePacket(pkt)
+	switch reply := reply.(type) {
+	// 서버가 옵션을 수락했다면 OACK가 0번 ACK를 대신한다
+	case *OACK:
+		if len(options) == 0 {
+			return 0, unexpectedPacket(pkt)
+		}
+
+		err = c.applyOACK(t, *reply)
+		if err != nil {
+			t.sendErr(ErrBadOption, err.Error())
+			return 0, err
+		}
+	// 옵션 없이 쓰기 요청을 수락하면 0번 ACK
+	case *Ack:
+		if *reply != 0 {
+			return 0, unexpectedPacket(pkt)
+		}
+	default:
+		return 0, unexpectedPacket(pkt)
+	}
+
+	// 보낸 bytes 수를 세기 위해 r 감싸기
+	cr := &countingReader{r: r}
+
+	// netascii mode라면 줄바꿈을 변환하면서 보내기
+	var src io.Reader = cr
+	if netascii {
+		src = NewNetASCIIReader(cr)
+	}
+
+	_, err = t.send(src)
+	if err != nil {
+		// 전송 중에 취소됐다면 서버에 알리기
+		t.sendAbort()
+		return cr.n, c.ctxErr(t, err)
+	}
+
+	return cr.n, nil
+}
+
+// 서버 주소를 해석하고 전송에 쓸 소켓 열기
+// 리턴하는 stop 함수로 소켓을 닫는다
+func (c Client) dial(ctx context.Context, addr string) (*transfer, func(), error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listen := c.ListenPacket
+	if listen == nil {
+		listen = net.ListenPacket
+	}
+
+	conn, err := listen("udp", ":0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t := &transfer{
+		conn:    conn,
+		peer:    raddr,
+		retries: c.Retries,
+		timeout: c.Timeout,
+		opts: transferOptions{
+			blockSize:  BlockSize,
+			windowSize: 1,
+			size:       -1,
+			rollover:   c.Rollover,
+		},
+	}
+
+	// 재시도 횟수가 0이면 10으로 초기화
+	if t.retries == 0 {
+		t.retries = 10
+	}
+
+	// Timeout이 0이면 6초로 초기화
+	if t.timeout == 0 {
+		t.timeout = 6 * time.Second
+	}
+
+	// 컨텍스트가 취소되면 전송 중단
+	unwatch := t.watch(ctx)
+	stop := func() {
+		unwatch()
+		_ = conn.Close()
+	}
+
+	return t, stop, nil
+}
+
+// 서버에 요청을 보내고 첫 응답 리턴
+// 서버는 새 포트(TID)에서 응답하므로 응답한 주소를 이후 전송의 상대로 정한다
+// 에러 패킷을 받으면 에러 리턴
+func (c Client) request(t *transfer, req []byte) ([]byte, error) {
+	var (
+		buf    = make([]byte, 4+MaxBlockSize)
+		server = t.peer.(*net.UDPAddr)
+	)
+
+RETRY:
+	for i := t.retries; i > 0; i-- {
+		err := t.write(req)
+		if err != nil {
+			return nil, fmt.Errorf("write: %w", err)
+		}
+
+		_ = t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+
+		for {
+			n, addr, err := t.conn.ReadFrom(buf)
+			if err != nil {
+				if t.aborted() {
+					return nil, errAborted
+				}
+				if isTimeout(err) {
+					continue RETRY
+				}
+
+				return nil, fmt.Errorf("waiting for response: %w", err)
+			}
+
+			// 서버와 다른 호스트에서 온 패킷은 ErrUnknownID로 응답하고 무시
+			if from, ok := addr.(*net.UDPAddr); ok && !from.IP.Equal(server.IP) {
+				t.rejectTID(addr, buf[:n])
+				continue
+			}
+
+			pkt, _ := ParsePacket(buf[:n])
+			if errPkt, ok := pkt.(*Err); ok {
+				return nil, fmt.Errorf("received error %d: %s", errPkt.Error, errPkt.Message)
+			}
+
+			t.peer = addr
+
+			return buf[:n], nil
+		}
+	}
+
+	return nil, errExhausted
+}
+
+// 서버가 OACK로 수락한 옵션을 전송 설정에 적용
+// 요청하지 않은 옵션이나 요청보다 큰 값이 오면 에러
+func (c Client) applyOACK(t *transfer, oack OACK) error {
+	for name, value := range oack {
+		requested, ok := c.Options[name]
+		if !ok {
+			return fmt.Errorf("unrequested option %q", name)
+		}
+
+		// multicast 값은 숫자가 아니므로 받을 때 따로 해석
+		if name == "multicast" {
+			continue
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+
+		// 요청한 값보다 큰 값은 받을 수 없다
+		max, _ := strconv.ParseInt(requested, 10, 64)
+
+		switch name {
+		case "blksize":
+			if n < MinBlockSize || n > max {
+				return fmt.Errorf("invalid blksize %q", value)
+			}
+			t.opts.blockSize = int(n)
+		case "windowsize":
+			if n < 1 || n > max {
+				return fmt.Errorf("invalid windowsize %q", value)
+			}
+			t.opts.windowSize = int(n)
+		case "timeout":
+			if value != requested {
+				return fmt.Errorf("invalid timeout %q", value)
+			}
+			t.opts.timeout = time.Duration(n) * time.Second
+		case "tsize":
+			t.opts.size = n
+		case "rollover":
+			if n != 0 && n != 1 {
+				return fmt.Errorf("invalid rollover %q", value)
+			}
+			t.opts.rollover = uint16(n)
+		default:
+			log.Printf("[%s] ignoring option %q", t.peer, name)
+		}
+	}
+
+	t.setOptions(t.opts)
+
+	return nil
+}
+
+// 컨텍스트가 취소되어 전송이 중단됐다면 컨텍스트 에러 리턴
+func (c Client) ctxErr(t *transfer, err error) error {
+	if t.aborted() {
+		return t.ctx.Err()
+	}
+
+	return err
+}
+
+// 기대하지 않은 패킷을 받았을 때의 에러
+func unexpectedPacket(p []byte) error {
+	if len(p) < 2 {
+		return errors.New("unexpected packet")
+	}
+
+	return fmt.Errorf("unexpected packet with opcode %d", OpCode(p[0])<<8|OpCode(p[1]))
+}
+
+// 기록한 bytes 수를 세는 io.Writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// 읽은 bytes 수를 세는 io.Reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,164 @@
+// 16 클라이언트 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestClientGet(t *testing.T) {
+	payload := make([]byte, 20*BlockSize+100)
+	_, _ = rand.Read(payload)
+
+	addr := startServer(t, &Server{FS: fstest.MapFS{
+		"payload":  {Data: payload},
+		"exact":    {Data: payload[:4*BlockSize]},
+		"small":    {Data: payload[:100]},
+		"empty":    {Data: nil},
+		"dir/file": {Data: payload[:10]},
+	}})
+
+	for _, c := range []struct {
+		filename string
+		options  map[string]string
+		expected []byte
+	}{
+		{"payload", nil, payload},
+		{"payload", map[string]string{"blksize": "1024", "windowsize": "4"}, payload},
+		{"payload", map[string]string{"tsize": "0", "timeout": "2"}, payload},
+		// 블록 크기의 배수라면 빈 블록으로 끝나야 한다
+		{"exact", nil, payload[:4*BlockSize]},
+		{"exact", map[string]string{"windowsize": "4"}, payload[:4*BlockSize]},
+		// 첫 블록이 마지막 블록인 경우
+		{"small", nil, payload[:100]},
+		{"empty", nil, nil},
+		{"/dir/file", nil, payload[:10]},
+	} {
+		client := Client{Timeout: time.Second, Options: c.options}
+
+		var received bytes.Buffer
+		n, err := client.Get(context.Background(), addr.String(), c.filename, &received)
+		if err != nil {
+			t.Errorf("%s %v: %v", c.filename, c.options, err)
+			continue
+		}
+
+		if !bytes.Equal(c.expected, received.Bytes()) {
+			t.Errorf("%s %v: expected %d bytes; actual %d bytes", c.filename, c.options, len(c.expected), received.Len())
+		}
+
+		if n != int64(len(c.expected)) {
+			t.Errorf("%s %v: expected count %d; actual %d", c.filename, c.options, len(c.expected), n)
+		}
+	}
+}
+
+func TestClientGetNotFound(t *testing.T) {
+	addr := startServer(t, &Server{FS: fstest.MapFS{}})
+
+	client := Client{Timeout: time.Second}
+	_, err := client.Get(context.Background(), addr.String(), "missing", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "received error 1") {
+		t.Fatalf("expected file not found error; actual %v", err)
+	}
+}
+
+func TestClientPut(t *testing.T) {
+	payload := make([]byte, 10*BlockSize+10)
+	_, _ = rand.Read(payload)
+
+	done := make(chan []byte, 1)
+	addr := startServer(t, &Server{
+		Upload: func(string) (io.WriteCloser, error) {
+			return &chanWriter{done: done}, nil
+		},
+		Timeout: time.Second,
+	})
+
+	for _, options := range []map[string]string{
+		nil,
+		{"blksize": "1024", "windowsize": "4", "tsize": "0"},
+	} {
+		client := Client{Timeout: time.Second, Options: options}
+
+		n, err := client.Put(context.Background(), addr.String(), "upload", bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("%v: %v", options, err)
+		}
+
+		if n != int64(len(payload)) {
+			t.Errorf("%v: expected count %d; actual %d", options, len(payload), n)
+		}
+
+		select {
+		case actual := <-done:
+			if !bytes.Equal(payload, actual) {
+				t.Errorf("%v: expected %d bytes; actual %d bytes", options, len(payload), len(actual))
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%v: upload was not closed", options)
+		}
+	}
+}
+
+func TestClientCanceled(t *testing.T) {
+	// 요청을 받기만 하고 응답하지 않는 서버
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	client := Client{Timeout: 5 * time.Second}
+	_, err = client.Get(ctx, conn.LocalAddr().String(), "payload", io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context deadline exceeded; actual %v", err)
+	}
+
+	// 재시도 Timeout까지 기다리지 않고 바로 끝나야 한다
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected cancellation within 1s; took %s", elapsed)
+	}
+}
+
+func TestClientRejectsUnrequestedOption(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// 요청하지 않은 blksize 옵션을 수락하는 서버
+	go func() {
+		buf := make([]byte, DatagramSize)
+		_, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		oack, _ := OACK{"blksize": "1024"}.MarshalBinary()
+		_, _ = conn.WriteTo(oack, addr)
+	}()
+
+	client := Client{
+		Retries: 1,
+		Timeout: time.Second,
+		Options: map[string]string{"windowsize": "4"},
+	}
+	_, err = client.Get(context.Background(), conn.LocalAddr().String(), "payload", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "unrequested option") {
+		t.Fatalf("expected unrequested option error; actual %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+// netascii mode 변환 (RFC 764, RFC 1350)
+// 보낼 때는 LF를 CR LF로, CR을 CR NUL로 바꾸고
+// 받을 때는 반대로 CR LF를 LF로, CR NUL을 CR로 되돌린다
+package tftp
+
+import "io"
+
+// netascii로 변환하면서 읽는 io.Reader
+type netasciiReader struct {
+	r io.Reader
+	// r에서 읽은 원본 데이터
+	in []byte
+	// 변환했지만 아직 읽어가지 않은 데이터
+	out []byte
+	buf []byte
+	err error
+}
+
+// r의 내용을 netascii로 변환하며 읽는 io.Reader 생성
+// 변환하면 크기가 달라지므로 리턴하는 io.Reader에서는 크기를 알 수 없다
+func NewNetASCIIReader(r io.Reader) io.Reader {
+	return &netasciiReader{r: r, in: make([]byte, BlockSize)}
+}
+
+func (n *netasciiReader) Read(p []byte) (int, error) {
+	// 변환해 둔 데이터가 없다면 원본에서 읽어 변환
+	for len(n.out) == 0 {
+		if n.err != nil {
+			return 0, n.err
+		}
+
+		var m int
+		m, n.err = n.r.Read(n.in)
+
+		n.buf = n.buf[:0]
+		for _, b := range n.in[:m] {
+			switch b {
+			case '\n':
+				n.buf = append(n.buf, '\r', '\n')
+			case '\r':
+				n.buf = append(n.buf, '\r', 0)
+			default:
+				n.buf = append(n.buf, b)
+			}
+		}
+		n.out = n.buf
+	}
+
+	m := copy(p, n.out)
+	n.out = n.out[m:]
+
+	return m, nil
+}
+
+// netascii로 받은 데이터를 되돌려서 기록하는 io.Writer
+type NetASCIIWriter struct {
+	w io.Writer
+	// 마지막으로 받은 바이트가 CR이라 다음 바이트를 기다리는 중
+	cr  bool
+	buf []byte
+}
+
+// netascii 데이터를 되돌려 w에 기록하는 NetASCIIWriter 생성
+// CR이 블록 경계에서 잘릴 수 있으므로 다 쓴 뒤에는 Flush를 호출해야 한다
+func NewNetASCIIWriter(w io.Writer) *NetASCIIWriter {
+	return &NetASCIIWriter{w: w}
+}
+
+func (n *NetASCIIWriter) Write(p []byte) (int, error) {
+	n.buf = n.buf[:0]
+
+	for _, b := range p {
+		if n.cr {
+			n.cr = false
+
+			switch b {
+			// CR LF는 LF
+			case '\n':
+				n.buf = append(n.buf, '\n')
+				continue
+			// CR NUL은 CR
+			case 0:
+				n.buf = append(n.buf, '\r')
+				continue
+			}
+
+			// 규칙에 맞지 않는 CR은 그대로 기록
+			n.buf = append(n.buf, '\r')
+		}
+
+		if b == '\r' {
+			n.cr = true
+			continue
+		}
+
+		n.buf = append(n.buf, b)
+	}
+
+	_, err := n.w.Write(n.buf)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// 마지막 바이트가 CR이었다면 그대로 기록
+func (n *NetASCIIWriter) Flush() error {
+	if !n.cr {
+		return nil
+	}
+	n.cr = false
+
+	_, err := n.w.Write([]byte{'\r'})
+
+	return err
+}
@@ -0,0 +1,159 @@
+// 19 netascii mode 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestNetASCII(t *testing.T) {
+	for _, c := range []struct {
+		decoded, encoded string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"line1\nline2\n", "line1\r\nline2\r\n"},
+		{"a\rb", "a\r\x00b"},
+		{"\r\n", "\r\x00\r\n"},
+		{"\n\n\r\r", "\r\n\r\n\r\x00\r\x00"},
+		{"end\r", "end\r\x00"},
+	} {
+		// 한 바이트씩 읽어도 변환 결과는 같아야 한다
+		encoded, err := io.ReadAll(NewNetASCIIReader(iotest.OneByteReader(bytes.NewBufferString(c.decoded))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != c.encoded {
+			t.Errorf("encoding %q: expected %q; actual %q", c.decoded, c.encoded, encoded)
+		}
+
+		// CR과 다음 바이트가 다른 Write로 나뉘어도 되돌릴 수 있어야 한다
+		var decoded bytes.Buffer
+		w := NewNetASCIIWriter(&decoded)
+		for i := 0; i < len(c.encoded); i++ {
+			_, err = w.Write([]byte{c.encoded[i]})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.String() != c.decoded {
+			t.Errorf("decoding %q: expected %q; actual %q", c.encoded, c.decoded, decoded.String())
+		}
+	}
+}
+
+func TestNetASCIIWriterBareCR(t *testing.T) {
+	// 규칙에 맞지 않는 CR은 그대로 기록
+	var decoded bytes.Buffer
+	w := NewNetASCIIWriter(&decoded)
+
+	_, err := w.Write([]byte("a\rb\r"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "a\rb\r"; decoded.String() != expected {
+		t.Errorf("expected %q; actual %q", expected, decoded.String())
+	}
+}
+
+func TestReadReqMode(t *testing.T) {
+	for _, c := range []struct {
+		mode, expected string
+		valid          bool
+	}{
+		{"octet", ModeOctet, true},
+		{"NETASCII", ModeNetASCII, true},
+		{"NetAscii", ModeNetASCII, true},
+		{"mail", "", false},
+	} {
+		b, err := ReadReq{Filename: "file", Mode: c.mode}.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var rrq ReadReq
+		err = rrq.UnmarshalBinary(b)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%s: expected error", c.mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.mode, err)
+			continue
+		}
+
+		if rrq.Mode != c.expected {
+			t.Errorf("%s: expected mode %q; actual %q", c.mode, c.expected, rrq.Mode)
+		}
+	}
+}
+
+func TestServerNetASCII(t *testing.T) {
+	// 블록 경계에 CR LF가 걸리도록 긴 텍스트 만들기
+	text := bytes.Repeat([]byte("line\r\n"), 300)
+	text = append(text, "last line\n"...)
+
+	done := make(chan []byte, 1)
+	addr := startServer(t, &Server{
+		Payload: text,
+		Upload: func(string) (io.WriteCloser, error) {
+			return &chanWriter{done: done}, nil
+		},
+		Timeout: time.Second,
+	})
+
+	// 네트워크에는 변환된 데이터가 오가야 한다
+	encoded, err := io.ReadAll(NewNetASCIIReader(bytes.NewReader(text)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, errPkt := download(t, addr, ReadReq{Filename: "text", Mode: ModeNetASCII})
+	if errPkt != nil {
+		t.Fatal(errPkt.Message)
+	}
+	if !bytes.Equal(encoded, raw) {
+		t.Errorf("expected %d encoded bytes; actual %d bytes", len(encoded), len(raw))
+	}
+
+	client := Client{Timeout: time.Second, Mode: ModeNetASCII}
+
+	var received bytes.Buffer
+	n, err := client.Get(context.Background(), addr.String(), "text", &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(text, received.Bytes()) {
+		t.Errorf("get: expected %d bytes; actual %d bytes", len(text), received.Len())
+	}
+	if n != int64(len(text)) {
+		t.Errorf("get: expected count %d; actual %d", len(text), n)
+	}
+
+	_, err = client.Put(context.Background(), addr.String(), "text", bytes.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case actual := <-done:
+		if !bytes.Equal(text, actual) {
+			t.Errorf("put: expected %d bytes; actual %d bytes", len(text), len(actual))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("upload was not closed")
+	}
+}
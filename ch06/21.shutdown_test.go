@@ -0,0 +1,272 @@
+// 02 서버 종료 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// 서버를 띄우고 리스너 주소와 Serve의 리턴 값을 받을 채널 리턴
+func serveCtx(t *testing.T, ctx context.Context, s *Server) (net.Addr, <-chan error) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(ctx, conn) }()
+
+	return conn.LocalAddr(), errc
+}
+
+// 읽기 요청을 보내고 첫 블록만 받은 뒤 ACK하지 않는 클라이언트
+// 클라이언트 소켓과 서버의 전송 주소 리턴
+func stalledDownload(t *testing.T, server net.Addr) (net.PacketConn, net.Addr) {
+	t.Helper()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	rrq, err := ReadReq{Filename: "payload"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.WriteTo(rrq, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		dataPkt Data
+		buf     = make([]byte, DatagramSize)
+	)
+
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, addr, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dataPkt.UnmarshalBinary(buf[:n]); err != nil || dataPkt.Block != 1 {
+		t.Fatalf("expected block 1; actual %v", buf[:n])
+	}
+
+	return client, addr
+}
+
+// 에러 패킷을 받을 때까지 기다리기
+func expectErrPacket(t *testing.T, client net.PacketConn) Err {
+	t.Helper()
+
+	var (
+		errPkt Err
+		buf    = make([]byte, DatagramSize)
+	)
+
+	for {
+		_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("expected error packet: %v", err)
+		}
+
+		// 서버가 재전송한 블록은 무시
+		if errPkt.UnmarshalBinary(buf[:n]) == nil {
+			return errPkt
+		}
+	}
+}
+
+func TestServerShutdownIdle(t *testing.T) {
+	s := &Server{Payload: []byte("payload")}
+	_, errc := serveCtx(t, context.Background(), s)
+
+	// Serve가 리스너를 등록할 때까지 잠시 기다리기
+	time.Sleep(50 * time.Millisecond)
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("expected ErrServerClosed; actual %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return")
+	}
+
+	// 종료한 서버는 다시 Serve할 수 없다
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err = s.Serve(context.Background(), conn); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed; actual %v", err)
+	}
+}
+
+func TestServerShutdownWaitsForTransfers(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, BlockSize+10)
+	s := &Server{Payload: payload, Timeout: time.Second}
+	addr, errc := serveCtx(t, context.Background(), s)
+
+	client, peer := stalledDownload(t, addr)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	// 전송이 끝나지 않았으므로 Shutdown은 기다려야 한다
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned before the transfer finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 새 요청은 받지 않는다
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("expected ErrServerClosed; actual %v", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 남은 전송 마치기
+	var (
+		dataPkt Data
+		buf     = make([]byte, DatagramSize)
+	)
+	for block := uint16(1); ; {
+		ack, _ := Ack(block).MarshalBinary()
+		if _, err := client.WriteTo(ack, peer); err != nil {
+			t.Fatal(err)
+		}
+		if block == 2 {
+			break
+		}
+
+		_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dataPkt.UnmarshalBinary(buf[:n]) == nil && dataPkt.Block == 2 {
+			block = 2
+		}
+	}
+
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Errorf("expected nil; actual %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+
+	if err := <-errc; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed; actual %v", err)
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, BlockSize+10)
+	s := &Server{Payload: payload, Timeout: 5 * time.Second}
+	addr, errc := serveCtx(t, context.Background(), s)
+
+	client, _ := stalledDownload(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// 기한이 지나면 진행 중인 전송을 중단하고 리턴해야 한다
+	start := time.Now()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded; actual %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Shutdown within 1s; took %s", elapsed)
+	}
+
+	// 클라이언트는 에러 패킷을 받아야 한다
+	if errPkt := expectErrPacket(t, client); errPkt.Error != ErrUnknown {
+		t.Errorf("expected error code %d; actual %d", ErrUnknown, errPkt.Error)
+	}
+
+	if err := <-errc; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed; actual %v", err)
+	}
+}
+
+func TestServerServeContextCanceled(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, BlockSize+10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr, errc := serveCtx(t, ctx, &Server{Payload: payload, Timeout: 5 * time.Second})
+
+	client, _ := stalledDownload(t, addr)
+
+	cancel()
+
+	// Serve는 진행 중인 전송을 중단하고 리턴해야 한다
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled; actual %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return")
+	}
+
+	expectErrPacket(t, client)
+}
+
+func TestClientCanceledNotifiesServer(t *testing.T) {
+	// 클라이언트가 전송 중에 취소하면 서버 전송도 바로 끝나야 한다
+	payload := bytes.Repeat([]byte{'x'}, 100*BlockSize)
+	s := &Server{Payload: payload, Timeout: 5 * time.Second}
+	addr, _ := serveCtx(t, context.Background(), s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &cancelWriter{cancel: cancel}
+
+	_, err := Client{Timeout: time.Second}.Get(ctx, addr.String(), "payload", w)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled; actual %v", err)
+	}
+
+	// 서버는 재시도를 다 하지 않고 전송을 정리해야 한다
+	shutdownCtx, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+
+	if err = s.Shutdown(shutdownCtx); err != nil {
+		t.Errorf("expected server transfer to end; actual %v", err)
+	}
+}
+
+// 처음 기록할 때 컨텍스트를 취소하는 io.Writer
+type cancelWriter struct {
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.cancel()
+
+	return len(p), nil
+}
@@ -0,0 +1,116 @@
+// 읽기 요청마다 보낼 내용을 만드는 핸들러
+package tftp
+
+import (
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+)
+
+// 읽기 요청에 보낼 내용을 만드는 인터페이스
+// 보낼 내용이나 클라이언트에게 보낼 에러 패킷 중 하나를 리턴한다
+// 리턴한 io.ReadCloser는 전송이 끝나면 서버가 닫는다
+type Handler interface {
+	ServeTFTP(addr net.Addr, rrq ReadReq) (io.ReadCloser, *Err)
+}
+
+// 일반 함수를 Handler로 쓰기 위한 타입
+type HandlerFunc func(addr net.Addr, rrq ReadReq) (io.ReadCloser, *Err)
+
+func (f HandlerFunc) ServeTFTP(addr net.Addr, rrq ReadReq) (io.ReadCloser, *Err) {
+	return f(addr, rrq)
+}
+
+// 파일명 패턴으로 요청을 핸들러에 나눠주는 Handler
+// 패턴은 path.Match 문법을 따르고 앞의 /는 무시한다
+// 메타 문자가 없는 패턴이 정확히 일치하면 먼저 쓰고
+// 그렇지 않으면 등록한 순서대로 처음 일치하는 패턴의 핸들러를 쓴다
+type ServeMux struct {
+	mu     sync.RWMutex
+	exact  map[string]Handler
+	routes []muxRoute
+}
+
+// 패턴과 핸들러
+type muxRoute struct {
+	pattern string
+	handler Handler
+}
+
+// 빈 ServeMux 생성
+func NewServeMux() *ServeMux {
+	return &ServeMux{exact: make(map[string]Handler)}
+}
+
+// pattern과 일치하는 파일명의 요청을 h로 처리
+// 잘못된 패턴이나 nil 핸들러, 이미 등록한 패턴이면 panic
+func (m *ServeMux) Handle(pattern string, h Handler) {
+	if h == nil {
+		panic("tftp: nil handler")
+	}
+
+	pattern = strings.TrimLeft(pattern, "/")
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic("tftp: invalid pattern " + pattern)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.exact == nil {
+		m.exact = make(map[string]Handler)
+	}
+
+	if _, ok := m.exact[pattern]; ok {
+		panic("tftp: multiple registrations for " + pattern)
+	}
+	for _, r := range m.routes {
+		if r.pattern == pattern {
+			panic("tftp: multiple registrations for " + pattern)
+		}
+	}
+
+	// 메타 문자가 없다면 정확히 일치하는 파일명만 처리
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		m.exact[pattern] = h
+		return
+	}
+
+	m.routes = append(m.routes, muxRoute{pattern: pattern, handler: h})
+}
+
+// pattern과 일치하는 파일명의 요청을 f로 처리
+func (m *ServeMux) HandleFunc(pattern string, f func(addr net.Addr, rrq ReadReq) (io.ReadCloser, *Err)) {
+	m.Handle(pattern, HandlerFunc(f))
+}
+
+// 파일명과 일치하는 핸들러로 요청 처리
+// 일치하는 핸들러가 없으면 ErrNotFound
+func (m *ServeMux) ServeTFTP(addr net.Addr, rrq ReadReq) (io.ReadCloser, *Err) {
+	h := m.match(strings.TrimLeft(rrq.Filename, "/"))
+	if h == nil {
+		return nil, &Err{Error: ErrNotFound, Message: "file not found"}
+	}
+
+	return h.ServeTFTP(addr, rrq)
+}
+
+// 파일명과 일치하는 핸들러 찾기
+func (m *ServeMux) match(name string) Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if h, ok := m.exact[name]; ok {
+		return h
+	}
+
+	for _, r := range m.routes {
+		if ok, _ := path.Match(r.pattern, name); ok {
+			return r.handler
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+// 22 핸들러 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// name을 내용으로 보내는 핸들러
+func nameHandler(name string) Handler {
+	return HandlerFunc(func(net.Addr, ReadReq) (io.ReadCloser, *Err) {
+		return io.NopCloser(strings.NewReader(name)), nil
+	})
+}
+
+func TestServeMux(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("pxelinux.cfg/default", nameHandler("default"))
+	mux.Handle("/pxelinux.cfg/01-*", nameHandler("mac"))
+	mux.Handle("pxelinux.cfg/*", nameHandler("any"))
+	mux.Handle("*.txt", nameHandler("txt"))
+
+	for _, c := range []struct {
+		filename, expected string
+	}{
+		{"pxelinux.cfg/default", "default"},
+		{"/pxelinux.cfg/default", "default"},
+		{"pxelinux.cfg/01-aa-bb-cc-dd-ee-ff", "mac"},
+		// 등록한 순서대로 처음 일치하는 패턴 사용
+		{"pxelinux.cfg/C0A80001", "any"},
+		{"readme.txt", "txt"},
+		// *는 /와 일치하지 않는다
+		{"docs/readme.txt", ""},
+		{"missing", ""},
+	} {
+		r, errPkt := mux.ServeTFTP(nil, ReadReq{Filename: c.filename})
+		if c.expected == "" {
+			if errPkt == nil || errPkt.Error != ErrNotFound {
+				t.Errorf("%s: expected ErrNotFound; actual %v", c.filename, errPkt)
+			}
+			continue
+		}
+		if errPkt != nil {
+			t.Errorf("%s: %s", c.filename, errPkt.Message)
+			continue
+		}
+
+		b, _ := io.ReadAll(r)
+		if string(b) != c.expected {
+			t.Errorf("%s: expected %q; actual %q", c.filename, c.expected, b)
+		}
+	}
+}
+
+func TestServeMuxPanics(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		pattern string
+		handler Handler
+	}{
+		{"nil handler", "file", nil},
+		{"invalid pattern", "[", nameHandler("x")},
+		{"duplicate", "file", nameHandler("x")},
+		{"duplicate with slash", "/file", nameHandler("x")},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", c.name)
+				}
+			}()
+
+			mux := NewServeMux()
+			mux.Handle("file", nameHandler("file"))
+			mux.Handle(c.pattern, c.handler)
+		}()
+	}
+}
+
+func TestServerHandler(t *testing.T) {
+	mux := NewServeMux()
+	// 요청한 클라이언트 주소로 내용 만들기
+	mux.HandleFunc("pxelinux.cfg/*", func(addr net.Addr, rrq ReadReq) (io.ReadCloser, *Err) {
+		host, _, _ := net.SplitHostPort(addr.String())
+		cfg := "DEFAULT " + strings.TrimPrefix(rrq.Filename, "pxelinux.cfg/") + " " + host + "\n"
+
+		return io.NopCloser(strings.NewReader(cfg)), nil
+	})
+	mux.HandleFunc("secret", func(net.Addr, ReadReq) (io.ReadCloser, *Err) {
+		return nil, &Err{Error: ErrAccessViolation, Message: "denied"}
+	})
+	// 기존 Payload 동작은 내장 핸들러로 사용
+	mux.Handle("payload", PayloadHandler([]byte("payload")))
+	// 아무것도 리턴하지 않는 핸들러
+	mux.HandleFunc("empty", func(net.Addr, ReadReq) (io.ReadCloser, *Err) {
+		return nil, nil
+	})
+
+	addr := startServer(t, &Server{Handler: mux, Timeout: time.Second})
+	client := Client{Timeout: time.Second}
+
+	for _, c := range []struct {
+		filename, expected, err string
+	}{
+		{"pxelinux.cfg/01-aa-bb", "DEFAULT 01-aa-bb 127.0.0.1\n", ""},
+		{"payload", "payload", ""},
+		{"secret", "", "received error 2: denied"},
+		{"empty", "", "received error 1: file not found"},
+		{"missing", "", "received error 1: file not found"},
+	} {
+		var received bytes.Buffer
+		_, err := client.Get(context.Background(), addr.String(), c.filename, &received)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%s: expected error %q; actual %v", c.filename, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.filename, err)
+			continue
+		}
+
+		if received.String() != c.expected {
+			t.Errorf("%s: expected %q; actual %q", c.filename, c.expected, received.String())
+		}
+	}
+}
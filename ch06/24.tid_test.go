@@ -0,0 +1,196 @@
+// 10 TID 검증 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 끼어드는 소켓에서 pkt을 to로 보내고 ErrUnknownID 응답 확인
+func interlope(t *testing.T, to net.Addr, pkt []byte) {
+	t.Helper()
+
+	// 끼어드는 연결 리스너 생성
+	interloper, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = interloper.Close() }()
+
+	_, err = interloper.WriteTo(pkt, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 다른 TID에서 보낸 패킷에는 ErrUnknownID로 응답해야 한다
+	buf := make([]byte, DatagramSize)
+	_ = interloper.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := interloper.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errPkt Err
+	if err = errPkt.UnmarshalBinary(buf[:n]); err != nil {
+		t.Fatalf("expected error packet; actual %v", buf[:n])
+	}
+	if errPkt.Error != ErrUnknownID {
+		t.Errorf("expected error code %d; actual %d", ErrUnknownID, errPkt.Error)
+	}
+
+	// 에러 패킷에는 응답하지 않아야 한다
+	errBytes, err := Err{Error: ErrUnknown, Message: "oops"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = interloper.WriteTo(errBytes, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = interloper.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err = interloper.ReadFrom(buf); err == nil {
+		t.Error("unexpected reply to error packet")
+	}
+}
+
+func TestServerUnknownTID(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 3*BlockSize/16)
+	addr := startServer(t, &Server{Payload: payload, Timeout: time.Second})
+
+	client, peer := stalledDownload(t, addr)
+
+	received := bytes.NewBuffer(nil)
+	received.Write(payload[:BlockSize])
+
+	// 전송 중에 다른 포트에서 서버의 전송 소켓으로 ACK를 보내 끼어들기
+	ack, err := Ack(3).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	interlope(t, peer, ack)
+
+	// 끼어든 패킷은 전송에 영향을 주지 않아야 한다
+	var (
+		dataPkt Data
+		buf     = make([]byte, DatagramSize)
+	)
+	for block := uint16(1); ; {
+		ack, _ := Ack(block).MarshalBinary()
+		if _, err = client.WriteTo(ack, peer); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameTID(from, peer) {
+			t.Fatalf("packet from %s; expected %s", from, peer)
+		}
+		if err = dataPkt.UnmarshalBinary(buf[:n]); err != nil {
+			t.Fatalf("expected data packet; actual %v", buf[:n])
+		}
+		if dataPkt.Block != block+1 {
+			continue
+		}
+
+		block = dataPkt.Block
+		_, _ = io.Copy(received, dataPkt.Payload)
+
+		// 마지막 블록 ACK
+		if n < DatagramSize {
+			ack, _ = Ack(block).MarshalBinary()
+			_, _ = client.WriteTo(ack, peer)
+			break
+		}
+	}
+
+	if !bytes.Equal(payload, received.Bytes()) {
+		t.Errorf("expected %d bytes; actual %d bytes", len(payload), received.Len())
+	}
+}
+
+// 두 번째 Read 전에 한 번 fn을 실행하는 io.ReadCloser
+type hookReader struct {
+	io.Reader
+	reads int
+	fn    func()
+}
+
+func (r *hookReader) Read(p []byte) (int, error) {
+	r.reads++
+	if r.reads == 2 {
+		r.fn()
+	}
+
+	return r.Reader.Read(p)
+}
+
+func (r *hookReader) Close() error { return nil }
+
+func TestClientUnknownTID(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 3*BlockSize/16)
+
+	// 클라이언트 소켓 주소를 알기 위해 ListenPacket 감싸기
+	clientAddr := make(chan net.Addr, 1)
+	listen := func(network, address string) (net.PacketConn, error) {
+		conn, err := net.ListenPacket(network, "127.0.0.1:")
+		if err == nil {
+			clientAddr <- conn.LocalAddr()
+		}
+
+		return conn, err
+	}
+
+	// 첫 블록의 ACK를 받은 뒤 서버 전송을 멈추고 끼어들 수 있도록 알리기
+	hooked := make(chan struct{})
+	resume := make(chan struct{})
+	addr := startServer(t, &Server{
+		Handler: HandlerFunc(func(net.Addr, ReadReq) (io.ReadCloser, *Err) {
+			return &hookReader{
+				Reader: bytes.NewReader(payload),
+				fn: func() {
+					close(hooked)
+					<-resume
+				},
+			}, nil
+		}),
+		Timeout: time.Second,
+	})
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var received bytes.Buffer
+		c := Client{Timeout: time.Second, ListenPacket: listen}
+		_, err := c.Get(context.Background(), addr.String(), "payload", &received)
+		done <- result{received.Bytes(), err}
+	}()
+
+	// 끼어드는 소켓에서 클라이언트로 가짜 데이터 보내기
+	<-hooked
+	data, err := (&Data{Block: 2, Payload: bytes.NewReader([]byte("bogus"))}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	interlope(t, <-clientAddr, data)
+	close(resume)
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	if !bytes.Equal(payload, r.data) {
+		t.Errorf("expected %d bytes; actual %d bytes", len(payload), len(r.data))
+	}
+}
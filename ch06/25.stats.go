@@ -0,0 +1,221 @@
+// 전송 통계와 이벤트
+package tftp
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 서버가 처리한 전송들의 누적 통계
+type Stats struct {
+	// 진행 중인 전송 수
+	Active int
+	// 끝까지 마친 전송 수와 실패한 전송 수
+	Transfers uint64
+	Failed    uint64
+	// 읽기 요청으로 보낸 bytes 수와 쓰기 요청으로 받은 bytes 수
+	BytesSent     uint64
+	BytesReceived uint64
+	// 이미 처리한 블록에 대한 늦거나 중복된 ACK와
+	// 순서가 어긋났거나 이미 받은 데이터 블록 수
+	Duplicates uint64
+	// 타임아웃으로 다시 보낸 패킷 수
+	Retransmits uint64
+	// 응답을 기다리다 타임아웃된 횟수
+	Timeouts uint64
+	// 클라이언트에게 보낸 에러 패킷 수
+	Errors map[ErrCode]uint64
+}
+
+// 전송 시간 히스토그램의 구간 (초)
+var durationBuckets = [...]float64{0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// 여러 전송이 같이 쓰는 카운터
+type transferStats struct {
+	duplicates    atomic.Uint64
+	retransmits   atomic.Uint64
+	timeouts      atomic.Uint64
+	transfers     atomic.Uint64
+	failed        atomic.Uint64
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+	// 에러 코드별 보낸 에러 패킷 수
+	// 정의되지 않은 코드는 ErrUnknown으로 센다
+	errors [ErrBadOption + 1]atomic.Uint64
+	// 전송 시간 히스토그램
+	durations durationHistogram
+}
+
+// 전송 시간 히스토그램
+// 구간별 전송 수와 합계, 전체 전송 수가 서로 맞도록 한 락으로 같이 기록하고 같이 읽는다
+type durationHistogram struct {
+	mu sync.Mutex
+	// 전송 시간이 구간 이하인 전송 수
+	buckets [len(durationBuckets)]uint64
+	// 전송 시간 합계와 전체 전송 수
+	sum   time.Duration
+	count uint64
+}
+
+// 전송 시간 d 기록
+func (h *durationHistogram) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, le := range durationBuckets {
+		if d.Seconds() <= le {
+			h.buckets[i]++
+		}
+	}
+	h.sum += d
+	h.count++
+}
+
+// 지금까지의 구간별 전송 수, 합계와 전체 전송 수
+func (h *durationHistogram) snapshot() ([len(durationBuckets)]uint64, time.Duration, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.buckets, h.sum, h.count
+}
+
+// 중복 패킷 하나 세기
+func (s *transferStats) duplicate() {
+	if s != nil {
+		s.duplicates.Add(1)
+	}
+}
+
+// 다시 보낸 패킷 n개 세기
+func (s *transferStats) retransmit(n int) {
+	if s != nil {
+		s.retransmits.Add(uint64(n))
+	}
+}
+
+// 타임아웃 하나 세기
+func (s *transferStats) timeout() {
+	if s != nil {
+		s.timeouts.Add(1)
+	}
+}
+
+// 보낸 에러 패킷 세기
+func (s *transferStats) errorSent(code ErrCode) {
+	if s == nil {
+		return
+	}
+
+	if int(code) >= len(s.errors) {
+		code = ErrUnknown
+	}
+	s.errors[code].Add(1)
+}
+
+// 끝난 전송 세기
+func (s *transferStats) finished(op OpCode, n int64, d time.Duration, err error) {
+	if err != nil {
+		s.failed.Add(1)
+	} else {
+		s.transfers.Add(1)
+	}
+
+	if op == OpWRQ {
+		s.bytesReceived.Add(uint64(n))
+	} else {
+		s.bytesSent.Add(uint64(n))
+	}
+
+	s.durations.observe(d)
+}
+
+// 지금까지의 통계
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	active := s.active
+	s.mu.Unlock()
+
+	stats := Stats{
+		Active:        active,
+		Transfers:     s.stats.transfers.Load(),
+		Failed:        s.stats.failed.Load(),
+		BytesSent:     s.stats.bytesSent.Load(),
+		BytesReceived: s.stats.bytesReceived.Load(),
+		Duplicates:    s.stats.duplicates.Load(),
+		Retransmits:   s.stats.retransmits.Load(),
+		Timeouts:      s.stats.timeouts.Load(),
+		Errors:        make(map[ErrCode]uint64),
+	}
+
+	for code := range s.stats.errors {
+		if n := s.stats.errors[code].Load(); n > 0 {
+			stats.Errors[ErrCode(code)] = n
+		}
+	}
+
+	return stats
+}
+
+// 전송 이벤트 종류
+type EventKind int
+
+const (
+	// 전송을 시작할 때
+	TransferStart EventKind = iota
+	// 전송이 끝나거나 실패했을 때
+	TransferFinish
+)
+
+// 전송마다 시작과 끝에 Server.OnTransfer로 전달하는 이벤트
+type TransferEvent struct {
+	Kind EventKind
+	// OpRRQ 또는 OpWRQ
+	Op       OpCode
+	Addr     net.Addr
+	Filename string
+	// 요청한 전송 모드, netascii 또는 octet
+	Mode  string
+	Start time.Time
+	// 아래는 TransferFinish 이벤트에서만 채운다
+	// 걸린 시간, 보내거나 받은 bytes 수, 실패했다면 그 이유
+	Duration time.Duration
+	Bytes    int64
+	Err      error
+	// 수락한 옵션과 주고받은 블록 수
+	Options OACK
+	Blocks  int
+	// Bytes만큼 보내거나 받은 내용의 SHA-512/256 체크섬
+	// 파일을 열기 전에 실패했다면 빈 문자열
+	Checksum string
+}
+
+// 전송 하나를 처리하면서 통계를 기록하고 이벤트 전달
+// fn은 옵션, 블록 수와 체크섬을 ev에 채운다
+func (s *Server) track(op OpCode, addr net.Addr, filename, mode string, fn func(ev *TransferEvent) (int64, error)) {
+	ev := TransferEvent{
+		Kind:     TransferStart,
+		Op:       op,
+		Addr:     addr,
+		Filename: filename,
+		Mode:     mode,
+		Start:    time.Now(),
+	}
+	if s.OnTransfer != nil {
+		s.OnTransfer(ev)
+	}
+
+	n, err := fn(&ev)
+
+	ev.Kind = TransferFinish
+	ev.Duration = time.Since(ev.Start)
+	ev.Bytes = n
+	ev.Err = err
+	s.stats.finished(op, n, ev.Duration, err)
+	s.audit(ev)
+
+	if s.OnTransfer != nil {
+		s.OnTransfer(ev)
+	}
+}
@@ -0,0 +1,94 @@
+// 25 중복 ACK 처리와 통계 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"tftp/lossy"
+	"time"
+)
+
+func TestServerIgnoresDuplicateAcks(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, 3*BlockSize+10)
+	s := &Server{Payload: payload, Timeout: time.Second}
+	addr := startServer(t, s)
+
+	client, peer := stalledDownload(t, addr)
+
+	var (
+		dataPkt Data
+		buf     = make([]byte, DatagramSize)
+	)
+
+	// 1번 블록의 ACK를 두 번 보내면 두 번째 ACK는 늦게 도착한 중복 ACK
+	ack, _ := Ack(1).MarshalBinary()
+	for i := 0; i < 2; i++ {
+		if _, err := client.WriteTo(ack, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dataPkt.UnmarshalBinary(buf[:n]); err != nil || dataPkt.Block != 2 {
+		t.Fatalf("expected block 2; actual %v", buf[:n])
+	}
+
+	// 중복 ACK에 응답해서 2번 블록을 다시 보내면 안 된다
+	_ = client.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if n, _, err = client.ReadFrom(buf); err == nil {
+		t.Fatalf("unexpected packet %v", buf[:n])
+	}
+
+	if stats := s.Stats(); stats.Duplicates != 1 || stats.Retransmits != 0 {
+		t.Errorf("expected 1 duplicate and 0 retransmits; actual %+v", stats)
+	}
+
+	// 타임아웃이 지나면 2번 블록을 다시 보내야 한다
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err = client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dataPkt.UnmarshalBinary(buf[:n]); err != nil || dataPkt.Block != 2 {
+		t.Fatalf("expected block 2; actual %v", buf[:n])
+	}
+
+	if stats := s.Stats(); stats.Retransmits != 1 {
+		t.Errorf("expected 1 retransmit; actual %+v", stats)
+	}
+}
+
+func TestServerStatsLossy(t *testing.T) {
+	payload := make([]byte, 100*BlockSize)
+	_, _ = rand.Read(payload)
+
+	n := lossy.NewNetwork(lossy.Config{Loss: 0.1, Seed: 3})
+	s := &Server{Payload: payload, Retries: 50, Timeout: 20 * time.Millisecond}
+	addr := startMemServer(t, n, s)
+
+	actual := memDownload(t, n, addr, ReadReq{
+		Filename: "payload",
+		Options:  map[string]string{"windowsize": "4"},
+	}, 20*time.Millisecond)
+
+	if !bytes.Equal(payload, actual) {
+		t.Fatalf("expected %d bytes; actual %d bytes", len(payload), len(actual))
+	}
+
+	// 유실이 있었다면 타임아웃으로 다시 보낸 블록이 있어야 한다
+	if stats := s.Stats(); stats.Retransmits == 0 {
+		t.Errorf("expected retransmits; actual %+v", stats)
+	}
+
+	// 다시 보낸 패킷은 유실된 패킷 수와 비슷해야 하고
+	// 중복 ACK마다 윈도우를 다시 보내던 때처럼 늘어나면 안 된다
+	sent, dropped := n.Stats().Sent, n.Stats().Dropped
+	if stats := s.Stats(); stats.Retransmits > uint64(4*dropped) {
+		t.Errorf("too many retransmits: %d for %d dropped of %d packets", stats.Retransmits, dropped, sent)
+	}
+}
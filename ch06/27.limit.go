@@ -0,0 +1,180 @@
+// 동시 전송 수와 전송 속도 제한
+package tftp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 동시 전송 수 제한을 넘지 않는다면 전송 자리 잡기
+// 자리를 잡았다면 전송이 끝난 뒤 같은 req로 release를 호출해야 한다
+// req는 requestKey로 만든 요청의 키
+func (s *Server) admit(addr net.Addr, req string) bool {
+	ip := hostOf(addr)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.MaxTransfers > 0 && s.active >= s.MaxTransfers {
+		return false
+	}
+	if s.MaxTransfersPerIP > 0 && s.activeByIP[ip] >= s.MaxTransfersPerIP {
+		return false
+	}
+
+	if s.activeByIP == nil {
+		s.activeByIP = make(map[string]int)
+		s.inflight = make(map[string]func())
+	}
+	s.active++
+	s.activeByIP[ip]++
+	s.inflight[req] = nil
+
+	return true
+}
+
+// admit로 잡은 전송 자리 돌려주기
+func (s *Server) release(addr net.Addr, req string) {
+	ip := hostOf(addr)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active--
+	if s.activeByIP[ip]--; s.activeByIP[ip] <= 0 {
+		delete(s.activeByIP, ip)
+	}
+	delete(s.inflight, req)
+}
+
+// 같은 클라이언트가 다시 보낸 요청인지 가리기 위한 요청의 키
+// 주소만으로 가리면 쓰기 전송이 마지막 ACK를 보내고 기다리는 동안
+// 같은 주소에서 온 새 요청까지 무시하므로 요청 내용도 넣는다
+func requestKey(addr net.Addr, op OpCode, filename, mode string) string {
+	return strings.Join([]string{addr.String(), op.String(), filename, mode}, "\x00")
+}
+
+// req가 진행 중인 전송을 시작한 요청이라면 true
+// 첫 응답이 유실되면 클라이언트가 같은 요청을 다시 보내는데
+// 이 요청으로 전송을 하나 더 시작하면 두 전송이 같은 클라이언트에게 응답하게 된다
+// 쓰기 요청이라면 나중 전송이 먼저 전송의 파일 때문에 ErrFileExists를 보내 업로드가 실패하므로 무시한다
+// 진행 중인 전송이 onRetry로 함수를 정했다면 대신 호출
+func (s *Server) retried(req string) bool {
+	s.mu.Lock()
+	fn, ok := s.inflight[req]
+	s.mu.Unlock()
+
+	if ok && fn != nil {
+		fn()
+	}
+
+	return ok
+}
+
+// 진행 중인 전송을 시작한 요청 req가 다시 오면 fn 호출
+// 전송이 스스로 다시 보내지 않는 응답이 유실됐을 때 다시 보낼 수 있다
+func (s *Server) onRetry(req string, fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.inflight[req]; ok {
+		s.inflight[req] = fn
+	}
+}
+
+// 포트를 뺀 주소의 IP
+func hostOf(addr net.Addr) string {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return udp.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// 초당 bytes 수를 제한하는 토큰 버킷
+// 토큰이 모자라면 빚을 지고 그만큼 기다리므로 한 번에 버킷보다 큰 패킷도 보낼 수 있다
+type rateLimiter struct {
+	mu sync.Mutex
+	// 초당 채워지는 토큰(bytes) 수
+	rate float64
+	// 쌓아둘 수 있는 최대 토큰 수
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// 초당 bytesPerSecond만큼 보낼 수 있는 제한 생성
+// 0 이하라면 제한하지 않으므로 nil 리턴
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	rate := float64(bytesPerSecond)
+
+	// 0.1초 분량까지 몰아서 보낼 수 있다
+	return &rateLimiter{rate: rate, burst: rate / 10, tokens: rate / 10}
+}
+
+// n bytes를 보내기 위해 기다려야 하는 시간
+func (l *rateLimiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// n bytes를 보내기 전에 전송별 제한과 전체 제한에 맞춰 기다리기
+func (t *transfer) throttle(n int) error {
+	var wait time.Duration
+	for _, l := range t.limits {
+		if d := l.reserve(n); d > wait {
+			wait = d
+		}
+	}
+
+	if wait == 0 {
+		return nil
+	}
+
+	ctx := t.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return errAborted
+	}
+}
@@ -0,0 +1,126 @@
+// 27 동시 전송 수와 전송 속도 제한 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 읽기 요청을 보내고 첫 응답이 "server busy" 에러 패킷인지 확인
+func expectBusy(t *testing.T, server net.Addr) {
+	t.Helper()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	rrq, err := ReadReq{Filename: "payload"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.WriteTo(rrq, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errPkt := expectErrPacket(t, client); errPkt.Message != "server busy" {
+		t.Errorf("expected server busy; actual %q", errPkt.Message)
+	}
+}
+
+func TestServerMaxTransfers(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, BlockSize+10)
+
+	for _, s := range []*Server{
+		{Payload: payload, MaxTransfers: 1},
+		{Payload: payload, MaxTransfersPerIP: 1},
+	} {
+		// 재시도 없이 짧게 기다리고 끝나도록 설정
+		s.Retries = 1
+		s.Timeout = 200 * time.Millisecond
+		addr := startServer(t, s)
+
+		// 첫 블록만 받고 멈춘 전송이 자리를 차지하고 있는 동안은 거부
+		stalledDownload(t, addr)
+		expectBusy(t, addr)
+
+		// 멈춘 전송이 포기하고 끝나면 다시 받을 수 있어야 한다
+		time.Sleep(500 * time.Millisecond)
+
+		client := Client{Timeout: time.Second}
+		_, err := client.Get(context.Background(), addr.String(), "payload", io.Discard)
+		if err != nil {
+			t.Errorf("%+v: %v", s, err)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1000)
+
+	// 처음 0.1초 분량은 바로 보낼 수 있다
+	if d := l.reserve(100); d != 0 {
+		t.Errorf("expected no wait; actual %s", d)
+	}
+
+	// 그 다음부터는 보낸 만큼 기다려야 한다
+	if d := l.reserve(500); d < 450*time.Millisecond || d > 550*time.Millisecond {
+		t.Errorf("expected about 500ms; actual %s", d)
+	}
+
+	// 제한이 없다면 기다리지 않는다
+	if d := newRateLimiter(0).reserve(1 << 20); d != 0 {
+		t.Errorf("expected no wait; actual %s", d)
+	}
+}
+
+func TestServerTransferRate(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, 20*1024)
+
+	for _, c := range []struct {
+		name    string
+		server  *Server
+		clients int
+	}{
+		// 전송 하나를 초당 40KB로 제한하면 20KB는 0.4초 이상 걸린다
+		{"transfer", &Server{Payload: payload, TransferRate: 40 * 1024}, 1},
+		// 전체를 초당 80KB로 제한하면 두 전송의 40KB도 0.4초 이상 걸린다
+		{"total", &Server{Payload: payload, TotalRate: 80 * 1024}, 2},
+	} {
+		addr := startServer(t, c.server)
+
+		var wg sync.WaitGroup
+		start := time.Now()
+		for i := 0; i < c.clients; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				var received bytes.Buffer
+				client := Client{Timeout: 2 * time.Second, Options: map[string]string{"windowsize": "8"}}
+				_, err := client.Get(context.Background(), addr.String(), "payload", &received)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(payload, received.Bytes()) {
+					t.Errorf("expected %d bytes; actual %d bytes", len(payload), received.Len())
+				}
+			}()
+		}
+		wg.Wait()
+
+		// 처음 0.1초 분량은 바로 보낼 수 있으므로 0.3초 이상
+		if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+			t.Errorf("%s: expected at least 300ms; took %s", c.name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// 클라이언트 IP와 파일명으로 요청을 허용하거나 거부하는 접근 제어
+package tftp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 요청을 허용할지 정하는 인터페이스
+// Serve가 전송을 시작하기 전에 읽기/쓰기 요청마다 호출한다
+type AccessControl interface {
+	Allowed(ip netip.Addr, filename string) bool
+}
+
+// 접근 제어 규칙 하나
+type AccessRule struct {
+	// true면 허용, false면 거부
+	Allow bool
+	// 규칙을 적용할 네트워크
+	Prefix netip.Prefix
+	// 규칙을 적용할 파일명 패턴 (path.Match 문법, 앞의 /는 무시)
+	// 비어있으면 모든 파일
+	Pattern string
+}
+
+// 요청이 규칙에 해당하는지 확인
+func (r AccessRule) match(ip netip.Addr, name string) bool {
+	if !r.Prefix.Contains(ip) {
+		return false
+	}
+
+	if r.Pattern == "" {
+		return true
+	}
+
+	ok, _ := path.Match(r.Pattern, name)
+
+	return ok
+}
+
+// 위에서부터 차례대로 확인해서 처음 일치하는 규칙을 따르는 접근 제어 목록
+// 일치하는 규칙이 없을 때 allow 규칙이 하나라도 있다면 거부하고 deny 규칙만 있다면 허용한다
+// allow 규칙으로 허용할 대상을 적었다면 나머지는 막으려는 것이므로 기본으로 거부하고
+// 나머지를 허용하려면 마지막에 allow 0.0.0.0/0,::/0 규칙을 둔다
+type AccessList []AccessRule
+
+func (l AccessList) Allowed(ip netip.Addr, filename string) bool {
+	ip = ip.Unmap()
+	name := strings.TrimLeft(filename, "/")
+
+	allowRules := false
+	for _, r := range l {
+		if r.match(ip, name) {
+			return r.Allow
+		}
+		allowRules = allowRules || r.Allow
+	}
+
+	return !allowRules
+}
+
+// 접근 제어 규칙 파일 파싱
+// 한 줄에 규칙 하나씩 "allow|deny 네트워크[,네트워크...] [파일명 패턴]" 형식이고 #부터는 주석
+//
+//	# 관리망에서만 키 파일 허용
+//	allow 10.0.0.0/24 *.key
+//	deny  0.0.0.0/0,::/0 *.key
+//	deny  192.168.100.0/24
+//	# allow 규칙이 있으므로 나머지를 허용하려면 직접 적는다
+//	allow 0.0.0.0/0,::/0
+func ParseAccessList(r io.Reader) (AccessList, error) {
+	var (
+		list AccessList
+		line int
+		s    = bufio.NewScanner(r)
+	)
+
+	for s.Scan() {
+		line++
+
+		text := s.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected action, networks and optional pattern", line)
+		}
+
+		var rule AccessRule
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			rule.Allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", line, fields[0])
+		}
+
+		if len(fields) == 3 {
+			rule.Pattern = strings.TrimLeft(fields[2], "/")
+			if _, err := path.Match(rule.Pattern, ""); err != nil {
+				return nil, fmt.Errorf("line %d: invalid pattern %q", line, fields[2])
+			}
+		}
+
+		for _, network := range strings.Split(fields[1], ",") {
+			prefix, err := parsePrefix(network)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+
+			rule.Prefix = prefix
+			list = append(list, rule)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// CIDR이나 IP 하나를 네트워크로 파싱
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+
+		return prefix.Masked(), nil
+	}
+
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// 파일에서 읽은 접근 제어 목록
+// 파일이 바뀌면 다음 요청 때 다시 읽는다
+type AccessFile struct {
+	path string
+	// 파일이 바뀌었는지 확인하는 최소 간격
+	interval time.Duration
+
+	mu      sync.Mutex
+	list    AccessList
+	modTime time.Time
+	checked time.Time
+}
+
+// 접근 제어 규칙 파일을 읽어 AccessFile 생성
+func LoadAccessFile(name string) (*AccessFile, error) {
+	f := &AccessFile{path: name, interval: time.Second}
+
+	err := f.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// 규칙 파일 다시 읽기
+// 파일이 잘못됐다면 기존 규칙을 그대로 쓰고 에러 리턴
+func (f *AccessFile) Reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	list, err := ParseAccessList(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	f.mu.Lock()
+	f.list = list
+	f.modTime = info.ModTime()
+	f.checked = time.Now()
+	f.mu.Unlock()
+
+	return nil
+}
+
+func (f *AccessFile) Allowed(ip netip.Addr, filename string) bool {
+	f.mu.Lock()
+	stale := time.Since(f.checked) >= f.interval
+	if stale {
+		f.checked = time.Now()
+	}
+	modTime := f.modTime
+	f.mu.Unlock()
+
+	// 마지막으로 확인한 뒤 시간이 지났다면 파일이 바뀌었는지 확인
+	if stale {
+		if info, err := os.Stat(f.path); err == nil && !info.ModTime().Equal(modTime) {
+			if err = f.Reload(); err != nil {
+				log.Printf("reloading access rules: %v", err)
+			}
+		}
+	}
+
+	f.mu.Lock()
+	list := f.list
+	f.mu.Unlock()
+
+	return list.Allowed(ip, filename)
+}
+
+// 요청한 클라이언트 주소의 IP
+func addrIP(addr net.Addr) netip.Addr {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		ip, _ := netip.AddrFromSlice(udp.IP)
+
+		return ip.Unmap()
+	}
+
+	ip, _ := netip.ParseAddr(hostOf(addr))
+
+	return ip.Unmap()
+}
@@ -0,0 +1,183 @@
+// 29 접근 제어 테스트하기
+package tftp
+
+import (
+	"context"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const testRules = `
+# 관리망에서만 키 파일 허용
+allow 10.0.0.0/24 *.key
+deny  0.0.0.0/0,::/0 *.key
+
+deny  192.168.100.0/24   # 격리된 장비
+allow 2001:db8::1
+deny  2001:db8::/32
+
+allow 0.0.0.0/0,::/0
+`
+
+func TestParseAccessList(t *testing.T) {
+	list, err := ParseAccessList(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 8 {
+		t.Fatalf("expected 8 rules; actual %d", len(list))
+	}
+
+	for _, bad := range []string{
+		"permit 10.0.0.0/8",
+		"allow",
+		"allow 10.0.0.0/33",
+		"allow 10.0.0.0/8 [",
+		"allow 10.0.0.0/8 *.key extra",
+	} {
+		if _, err = ParseAccessList(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestAccessList(t *testing.T) {
+	list, err := ParseAccessList(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		ip       string
+		filename string
+		allowed  bool
+	}{
+		{"10.0.0.5", "host.key", true},
+		{"10.0.0.5", "/host.key", true},
+		{"10.0.1.5", "host.key", false},
+		{"10.0.1.5", "pxelinux.0", true},
+		{"192.168.100.7", "pxelinux.0", false},
+		// IPv4에 대응된 IPv6 주소도 IPv4 규칙을 따른다
+		{"::ffff:10.0.0.5", "host.key", true},
+		{"::ffff:192.168.100.7", "pxelinux.0", false},
+		{"2001:db8::1", "pxelinux.0", true},
+		{"2001:db8::2", "pxelinux.0", false},
+		{"2001:db8::1", "host.key", false},
+	} {
+		if actual := list.Allowed(netip.MustParseAddr(c.ip), c.filename); actual != c.allowed {
+			t.Errorf("%s %s: expected allowed %t; actual %t", c.ip, c.filename, c.allowed, actual)
+		}
+	}
+}
+
+func TestAccessListDefault(t *testing.T) {
+	for _, c := range []struct {
+		rules   string
+		ip      string
+		allowed bool
+	}{
+		// allow 규칙만 있다면 적은 대상 외에는 거부
+		{"allow 10.0.0.0/24", "10.0.0.5", true},
+		{"allow 10.0.0.0/24", "10.0.1.5", false},
+		{"allow 10.0.0.0/24", "2001:db8::1", false},
+		// deny 규칙만 있다면 적은 대상 외에는 허용
+		{"deny 10.0.0.0/24", "10.0.0.5", false},
+		{"deny 10.0.0.0/24", "10.0.1.5", true},
+		// 규칙이 없다면 모두 허용
+		{"", "10.0.1.5", true},
+	} {
+		list, err := ParseAccessList(strings.NewReader(c.rules))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := list.Allowed(netip.MustParseAddr(c.ip), "pxelinux.0"); actual != c.allowed {
+			t.Errorf("%q %s: expected allowed %t; actual %t", c.rules, c.ip, c.allowed, actual)
+		}
+	}
+}
+
+func TestAccessFileReload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rules")
+	if err := os.WriteFile(name, []byte("deny 127.0.0.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := LoadAccessFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 매번 파일이 바뀌었는지 확인
+	f.interval = 0
+
+	localhost := netip.MustParseAddr("127.0.0.1")
+	if f.Allowed(localhost, "file") {
+		t.Fatal("expected denied")
+	}
+
+	// 파일을 바꾸면 다시 읽어야 한다
+	if err = os.WriteFile(name, []byte("allow 127.0.0.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err = os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.Allowed(localhost, "file") {
+		t.Fatal("expected allowed after reload")
+	}
+
+	// 잘못된 파일이라면 기존 규칙 유지
+	if err = os.WriteFile(name, []byte("maybe 127.0.0.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	if err = os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.Allowed(localhost, "file") {
+		t.Fatal("expected previous rules to remain")
+	}
+}
+
+func TestServerAccess(t *testing.T) {
+	addr := startServer(t, &Server{
+		FS: fstest.MapFS{
+			"host.key":   {Data: []byte("secret")},
+			"pxelinux.0": {Data: []byte("boot")},
+		},
+		Upload: func(string) (io.WriteCloser, error) {
+			return &chanWriter{done: make(chan []byte, 1)}, nil
+		},
+		Access: AccessList{
+			{Allow: false, Prefix: netip.MustParsePrefix("127.0.0.0/8"), Pattern: "*.key"},
+		},
+		Timeout: time.Second,
+	})
+
+	client := Client{Timeout: time.Second}
+
+	_, err := client.Get(context.Background(), addr.String(), "host.key", io.Discard)
+	if err == nil || err.Error() != "received error 2: access denied" {
+		t.Errorf("expected access denied; actual %v", err)
+	}
+
+	_, err = client.Put(context.Background(), addr.String(), "new.key", strings.NewReader("key"))
+	if err == nil || err.Error() != "received error 2: access denied" {
+		t.Errorf("expected access denied; actual %v", err)
+	}
+
+	_, err = client.Get(context.Background(), addr.String(), "pxelinux.0", io.Discard)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,94 @@
+// 전송 통계를 Prometheus 텍스트 형식으로 내보내기
+package tftp
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// 서버 통계를 Prometheus 텍스트 형식(0.0.4)으로 응답하는 http.Handler
+//
+//	http.Handle("/metrics", s.MetricsHandler())
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		b := bufio.NewWriter(w)
+		s.writeMetrics(b)
+		_ = b.Flush()
+	})
+}
+
+// 통계를 Prometheus 텍스트 형식으로 쓰기
+func (s *Server) writeMetrics(w *bufio.Writer) {
+	stats := s.Stats()
+
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("tftp_active_transfers", "gauge", "Number of transfers in progress.")
+	fmt.Fprintf(w, "tftp_active_transfers %d\n", stats.Active)
+
+	metric("tftp_transfers_total", "counter", "Number of finished transfers by result.")
+	fmt.Fprintf(w, "tftp_transfers_total{result=\"ok\"} %d\n", stats.Transfers)
+	fmt.Fprintf(w, "tftp_transfers_total{result=\"error\"} %d\n", stats.Failed)
+
+	metric("tftp_sent_bytes_total", "counter", "Bytes sent in response to read requests.")
+	fmt.Fprintf(w, "tftp_sent_bytes_total %d\n", stats.BytesSent)
+
+	metric("tftp_received_bytes_total", "counter", "Bytes received from write requests.")
+	fmt.Fprintf(w, "tftp_received_bytes_total %d\n", stats.BytesReceived)
+
+	metric("tftp_retransmits_total", "counter", "Packets resent after a timeout.")
+	fmt.Fprintf(w, "tftp_retransmits_total %d\n", stats.Retransmits)
+
+	metric("tftp_duplicates_total", "counter", "Duplicate or stale packets ignored.")
+	fmt.Fprintf(w, "tftp_duplicates_total %d\n", stats.Duplicates)
+
+	metric("tftp_timeouts_total", "counter", "Timeouts while waiting for the peer.")
+	fmt.Fprintf(w, "tftp_timeouts_total %d\n", stats.Timeouts)
+
+	// 에러 코드 순서대로 쓰기
+	metric("tftp_errors_total", "counter", "Error packets sent by error code.")
+	codes := make([]int, 0, len(stats.Errors))
+	for code := range stats.Errors {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "tftp_errors_total{code=\"%d\"} %d\n", code, stats.Errors[ErrCode(code)])
+	}
+
+	// 파일 캐시를 쓰는 경우 캐시 통계
+	if s.Cache != nil {
+		cache := s.Cache.Stats()
+
+		metric("tftp_cache_hits_total", "counter", "Requests served from the file cache.")
+		fmt.Fprintf(w, "tftp_cache_hits_total %d\n", cache.Hits)
+
+		metric("tftp_cache_misses_total", "counter", "Requests read from the file system.")
+		fmt.Fprintf(w, "tftp_cache_misses_total %d\n", cache.Misses)
+
+		metric("tftp_cache_evictions_total", "counter", "Files evicted from the file cache.")
+		fmt.Fprintf(w, "tftp_cache_evictions_total %d\n", cache.Evictions)
+
+		metric("tftp_cache_bytes", "gauge", "Bytes held in the file cache.")
+		fmt.Fprintf(w, "tftp_cache_bytes %d\n", cache.Bytes)
+	}
+
+	// 전송 시간 히스토그램
+	// 구간이 +Inf보다 커지지 않도록 구간, 합계와 전체 수는 위의 통계와 따로 한 번에 읽는다
+	buckets, sum, count := s.stats.durations.snapshot()
+	metric("tftp_transfer_duration_seconds", "histogram", "Duration of finished transfers.")
+	for i, le := range durationBuckets {
+		fmt.Fprintf(w, "tftp_transfer_duration_seconds_bucket{le=\"%s\"} %d\n",
+			strconv.FormatFloat(le, 'g', -1, 64), buckets[i])
+	}
+	fmt.Fprintf(w, "tftp_transfer_duration_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(w, "tftp_transfer_duration_seconds_sum %g\n", sum.Seconds())
+	fmt.Fprintf(w, "tftp_transfer_duration_seconds_count %d\n", count)
+}
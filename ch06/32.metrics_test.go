@@ -0,0 +1,188 @@
+// 25, 31 통계와 이벤트, Prometheus 내보내기 테스트하기
+package tftp
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestServerEvents(t *testing.T) {
+	payload := []byte(strings.Repeat("boot", 300))
+	events := make(chan TransferEvent, 10)
+
+	s := &Server{
+		FS:         fstest.MapFS{"pxelinux.0": {Data: payload}},
+		Timeout:    time.Second,
+		OnTransfer: func(ev TransferEvent) { events <- ev },
+	}
+	addr := startServer(t, s)
+	client := Client{Timeout: time.Second}
+
+	_, err := client.Get(context.Background(), addr.String(), "pxelinux.0", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Get(context.Background(), addr.String(), "missing", io.Discard)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// 클라이언트가 마지막 ACK를 보낸 뒤에 서버 전송이 끝나므로
+	// 이벤트 순서는 전송별로만 정해져 있다
+	started := make(map[string]TransferEvent)
+	finished := make(map[string]TransferEvent)
+	for i := 0; i < 4; i++ {
+		select {
+		case ev := <-events:
+			if ev.Kind == TransferStart {
+				if _, ok := finished[ev.Filename]; ok {
+					t.Errorf("%s: finish event before start", ev.Filename)
+				}
+				started[ev.Filename] = ev
+			} else {
+				finished[ev.Filename] = ev
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("missing events")
+		}
+	}
+
+	// 전송마다 시작과 끝 이벤트
+	for _, c := range []struct {
+		filename string
+		bytes    int64
+		failed   bool
+	}{
+		{"pxelinux.0", int64(len(payload)), false},
+		{"missing", 0, true},
+	} {
+		start, finish := started[c.filename], finished[c.filename]
+
+		if start.Kind != TransferStart || start.Op != OpRRQ || start.Filename != c.filename {
+			t.Errorf("%s: unexpected start event %+v", c.filename, start)
+		}
+		if finish.Kind != TransferFinish || finish.Filename != c.filename {
+			t.Errorf("%s: unexpected finish event %+v", c.filename, finish)
+		}
+		if finish.Bytes != c.bytes {
+			t.Errorf("%s: expected %d bytes; actual %d", c.filename, c.bytes, finish.Bytes)
+		}
+		if (finish.Err != nil) != c.failed {
+			t.Errorf("%s: expected failed %t; actual error %v", c.filename, c.failed, finish.Err)
+		}
+		if finish.Duration <= 0 {
+			t.Errorf("%s: expected positive duration", c.filename)
+		}
+	}
+
+	stats := s.Stats()
+	if stats.Transfers != 1 || stats.Failed != 1 {
+		t.Errorf("unexpected transfer counts %+v", stats)
+	}
+	if stats.BytesSent != uint64(len(payload)) {
+		t.Errorf("expected %d bytes sent; actual %d", len(payload), stats.BytesSent)
+	}
+	if stats.Errors[ErrNotFound] != 1 {
+		t.Errorf("expected 1 not found error; actual %v", stats.Errors)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	s := &Server{Cache: NewFileCache(1 << 10)}
+	s.Cache.stats.Hits = 4
+	s.Cache.stats.Misses = 1
+	s.stats.finished(OpRRQ, 1000, 200*time.Millisecond, nil)
+	s.stats.finished(OpWRQ, 10, 2*time.Second, io.ErrUnexpectedEOF)
+	s.stats.retransmit(3)
+	s.stats.errorSent(ErrNotFound)
+	s.stats.errorSent(ErrNotFound)
+	s.stats.errorSent(ErrCode(99))
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE tftp_active_transfers gauge",
+		"tftp_active_transfers 0",
+		`tftp_transfers_total{result="ok"} 1`,
+		`tftp_transfers_total{result="error"} 1`,
+		"tftp_sent_bytes_total 1000",
+		"tftp_received_bytes_total 10",
+		"tftp_retransmits_total 3",
+		`tftp_errors_total{code="0"} 1`,
+		`tftp_errors_total{code="1"} 2`,
+		"# TYPE tftp_transfer_duration_seconds histogram",
+		`tftp_transfer_duration_seconds_bucket{le="0.1"} 0`,
+		`tftp_transfer_duration_seconds_bucket{le="0.5"} 1`,
+		`tftp_transfer_duration_seconds_bucket{le="5"} 2`,
+		`tftp_transfer_duration_seconds_bucket{le="+Inf"} 2`,
+		"tftp_transfer_duration_seconds_sum 2.2",
+		"tftp_transfer_duration_seconds_count 2",
+		"tftp_cache_hits_total 4",
+		"tftp_cache_misses_total 1",
+		"tftp_cache_bytes 0",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsHistogramConsistent(t *testing.T) {
+	s := &Server{}
+
+	// 전송이 끝나는 중에 내보내도 구간의 수가 +Inf와 _count를 넘으면 안 된다
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.stats.finished(OpRRQ, 1, time.Millisecond, nil)
+			}
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		rec := httptest.NewRecorder()
+		s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+		var buckets []string
+		inf, count := "", ""
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			fields := strings.Fields(line)
+			switch {
+			case strings.HasPrefix(line, `tftp_transfer_duration_seconds_bucket{le="+Inf"}`):
+				inf = fields[1]
+			case strings.HasPrefix(line, "tftp_transfer_duration_seconds_bucket"):
+				buckets = append(buckets, fields[1])
+			case strings.HasPrefix(line, "tftp_transfer_duration_seconds_count"):
+				count = fields[1]
+			}
+		}
+
+		// 모든 전송이 첫 구간에 들어가므로 모든 구간이 +Inf, _count와 같아야 한다
+		for _, b := range buckets {
+			if b != inf || inf != count {
+				t.Fatalf("inconsistent histogram: buckets %v, +Inf %s, count %s", buckets, inf, count)
+			}
+		}
+	}
+}
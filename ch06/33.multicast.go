@@ -0,0 +1,767 @@
+// 멀티캐스트 옵션 (RFC 2090)
+// 같은 파일을 받는 클라이언트들을 한 세션으로 묶어서 데이터를 멀티캐스트 그룹으로 보낸다
+// 세션의 클라이언트 중 하나인 마스터만 ACK하고 서버는 마스터의 ACK에 맞춰 블록을 보낸다
+// 중간에 참여한 클라이언트는 그룹으로 오는 블록부터 받다가
+// 마스터가 되면 놓친 첫 블록 앞까지 ACK해서 놓친 블록을 다시 받는다
+package tftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 멀티캐스트 세션에 참여한 클라이언트
+type mcastClient struct {
+	addr net.Addr
+	// 세션이 이 클라이언트를 끝내면 닫는다
+	done chan struct{}
+	// 다 받지 못하고 끝났다면 그 이유
+	err error
+}
+
+// 클라이언트가 끝나길 기다렸다가 보낸 bytes 수와 에러 리턴
+func (c *mcastClient) result(size int64) (int64, error) {
+	<-c.done
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return size, nil
+}
+
+// 다 받은 클라이언트라면 세션의 블록 수와 체크섬을 ev에 채우기
+// c가 끝난 뒤에 호출해야 한다
+func (m *mcastSession) report(c *mcastClient, ev *TransferEvent) {
+	if c.err != nil {
+		return
+	}
+
+	ev.Blocks = int(m.last)
+	ev.Checksum = m.sum
+}
+
+// 같은 파일을 여러 클라이언트에게 그룹으로 보내는 세션
+type mcastSession struct {
+	s *Server
+	// Server.groups에 등록한 키
+	key string
+	// 세션을 연 전송, 이 전송의 소켓이 세션의 TID
+	t     *transfer
+	group *net.UDPAddr
+	src   io.ReaderAt
+	size  int64
+	// 블록 크기와 마지막 블록 번호
+	blockSize int
+	last      uint16
+	// 참여한 순서대로의 클라이언트, s.mu로 보호
+	clients []*mcastClient
+	// 아래는 세션 고루틴만 쓴다
+	// 지금의 마스터
+	master *mcastClient
+	// 마지막으로 그룹에 보낸 블록 번호
+	block uint16
+	// 타임아웃이면 다시 보낼 패킷과 받을 주소, 남은 재시도 횟수
+	pkt   []byte
+	to    net.Addr
+	tries uint8
+	// 보내는 내용의 체크섬과 계산했는지 여부
+	// 클라이언트를 다 받은 것으로 끝내기 전에 채우므로 끝난 클라이언트는 읽을 수 있다
+	sum    string
+	summed bool
+}
+
+// 멀티캐스트로 보낼 수 있는 요청이라면 블록을 아무 위치에서나 읽을 수 있는 src 리턴
+// 블록을 다시 보내려면 임의 위치에서 읽어야 하고 블록 번호가 돌아가면 안 되므로
+// netascii mode나 크기를 모르는 내용, 블록이 65535개보다 많은 파일은 유니캐스트로 보낸다
+func (s *Server) multicastSource(clientAddr string, rrq ReadReq, src io.Reader, opts transferOptions) (io.ReaderAt, bool) {
+	if !opts.multicast {
+		return nil, false
+	}
+
+	ra, ok := src.(io.ReaderAt)
+	if ok && rrq.Mode != ModeNetASCII && opts.size >= 0 && opts.size/int64(opts.blockSize) < math.MaxUint16 {
+		return ra, true
+	}
+
+	log.Printf("[%s] cannot multicast %s, sending unicast", clientAddr, rrq.Filename)
+
+	return nil, false
+}
+
+// 같은 파일과 블록 크기의 세션이 있다면 참여하고 없다면 t의 소켓으로 새 세션 시작
+// 이 클라이언트가 다 받거나 실패할 때까지 기다렸다가 보낸 bytes 수 리턴
+// 다 받았다면 블록 수와 체크섬은 ev에 채운다
+// 세션을 연 전송은 세션의 모든 클라이언트가 끝날 때까지 리턴하지 않는다
+func (s *Server) serveMulticast(t *transfer, rrq ReadReq, src io.ReaderAt, oack OACK, ev *TransferEvent) (int64, error) {
+	clientAddr := t.peer.String()
+
+	// 마스터의 ACK 하나마다 블록 하나를 보내므로 windowsize는 수락하지 않는다
+	delete(oack, "windowsize")
+	t.opts.windowSize = 1
+
+	key := rrq.Filename + "\x00" + strconv.Itoa(t.opts.blockSize)
+
+	// 새 세션은 OACK를 보내기 전에 등록해서 핸드셰이크 중에 온 요청도 같은 세션에 참여시킨다
+	// 여러 클라이언트가 한꺼번에 부팅하면 대부분 이 사이에 요청한다
+	s.mu.Lock()
+	m := s.groups[key]
+	opened := m == nil
+	if opened {
+		m = &mcastSession{
+			s:         s,
+			key:       key,
+			t:         t,
+			group:     s.Multicast,
+			src:       src,
+			size:      t.opts.size,
+			blockSize: t.opts.blockSize,
+			last:      uint16(t.opts.size/int64(t.opts.blockSize) + 1),
+		}
+		if s.groups == nil {
+			s.groups = make(map[string]*mcastSession)
+		}
+		s.groups[key] = m
+	}
+	c := m.add(t.peer)
+	s.mu.Unlock()
+	defer m.report(c, ev)
+
+	if !opened {
+		return m.join(t, c, rrq, oack)
+	}
+
+	if s.MulticastInterface != nil {
+		err := setMulticastInterface(t.conn, s.MulticastInterface, s.Multicast.IP)
+		if err != nil {
+			log.Printf("[%s] multicast interface: %v", clientAddr, err)
+		}
+	}
+
+	log.Printf("[%s] multicasting %s to %s", clientAddr, rrq.Filename, s.Multicast)
+
+	// 세션을 연 클라이언트가 첫 마스터
+	oack["multicast"] = m.option(true)
+	err := t.sendOACK(oack)
+	if err != nil {
+		log.Printf("[%s] %v", clientAddr, err)
+		t.sendAbort()
+
+		// 핸드셰이크 중에 참여한 클라이언트가 있다면 그 중 하나를 마스터로 정해서 세션을 이어간다
+		// 이 전송의 소켓이 세션의 TID이므로 세션이 끝날 때까지 리턴하지 않는다
+		m.remove(c, err)
+		if m.promote() {
+			m.run()
+		}
+		return 0, err
+	}
+
+	// 첫 마스터는 OACK에 0번 ACK로 답했으므로 1번 블록부터
+	m.master = c
+	err = m.sendBlock(1)
+	if err != nil {
+		m.stop(err)
+	} else {
+		m.run()
+	}
+
+	return c.result(m.size)
+}
+
+// OACK에 보낼 multicast 옵션 값 "주소,포트,마스터 여부"
+func (m *mcastSession) option(master bool) string {
+	mc := 0
+	if master {
+		mc = 1
+	}
+
+	return fmt.Sprintf("%s,%d,%d", m.group.IP, m.group.Port, mc)
+}
+
+// 클라이언트를 세션에 추가, s.mu를 잡고 호출해야 한다
+// 요청을 다시 보낸 클라이언트라면 이미 참여한 클라이언트 리턴
+func (m *mcastSession) add(addr net.Addr) *mcastClient {
+	for _, c := range m.clients {
+		if sameTID(c.addr, addr) {
+			return c
+		}
+	}
+
+	c := &mcastClient{addr: addr, done: make(chan struct{})}
+	m.clients = append(m.clients, c)
+
+	return c
+}
+
+// addr의 클라이언트, 세션의 클라이언트가 아니면 nil
+func (m *mcastSession) client(addr net.Addr) *mcastClient {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, c := range m.clients {
+		if sameTID(c.addr, addr) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// 클라이언트를 세션에서 빼고 err로 끝내기
+// 이미 끝난 클라이언트라면 아무 일도 하지 않는다
+func (m *mcastSession) remove(c *mcastClient, err error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for i, cc := range m.clients {
+		if cc == c {
+			m.clients = append(m.clients[:i], m.clients[i+1:]...)
+			c.err = err
+			close(c.done)
+			return
+		}
+	}
+}
+
+// 진행 중인 세션에 마스터가 아닌 클라이언트로 참여
+// 세션 소켓에서 보낸 OACK를 받은 클라이언트는 그룹으로 오는 블록부터 받는다
+// OACK가 유실되면 클라이언트가 요청을 다시 보내므로 OACK는 그때 다시 보낸다
+func (m *mcastSession) join(t *transfer, c *mcastClient, rrq ReadReq, oack OACK) (int64, error) {
+	oack["multicast"] = m.option(false)
+
+	b, err := oack.MarshalBinary()
+	if err != nil {
+		m.remove(c, err)
+		return 0, fmt.Errorf("preparing oack packet: %w", err)
+	}
+
+	_, err = m.t.conn.WriteTo(b, c.addr)
+	if err != nil {
+		m.remove(c, err)
+		return 0, fmt.Errorf("write: %w", err)
+	}
+	m.s.onRetry(requestKey(c.addr, OpRRQ, rrq.Filename, rrq.Mode), func() { _, _ = m.t.conn.WriteTo(b, c.addr) })
+
+	log.Printf("[%s] joined multicast session from %s", c.addr, m.t.peer)
+
+	select {
+	case <-c.done:
+	// 이 클라이언트의 요청을 받은 리스너가 중단되면 혼자 세션에서 빠진다
+	case <-t.ctx.Done():
+		m.remove(c, errAborted)
+		m.sendErr(c.addr, ErrUnknown, "transfer aborted")
+	}
+
+	return c.result(m.size)
+}
+
+// 세션 소켓으로 클라이언트에게 에러 패킷 보내기
+func (m *mcastSession) sendErr(addr net.Addr, code ErrCode, msg string) {
+	m.t.stats.errorSent(code)
+
+	b, err := Err{Error: code, Message: msg}.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	_, _ = m.t.conn.WriteTo(b, addr)
+}
+
+// block 번호의 데이터 패킷
+func (m *mcastSession) data(block uint16) ([]byte, error) {
+	p := make([]byte, m.blockSize)
+
+	n, err := m.src.ReadAt(p, int64(block-1)*int64(m.blockSize))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	// MarshalBinary가 블록 번호를 1 늘린다
+	return (&Data{Block: block - 1, Payload: bytes.NewReader(p[:n]), Size: m.blockSize}).MarshalBinary()
+}
+
+// 패킷을 보내고 응답을 기다릴 데드라인 설정
+// 타임아웃이면 다시 보낼 수 있도록 기억해 둔다
+func (m *mcastSession) write(b []byte, to net.Addr) error {
+	err := m.t.throttle(len(b))
+	if err != nil {
+		return err
+	}
+
+	_, err = m.t.conn.WriteTo(b, to)
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	m.pkt, m.to = b, to
+	_ = m.t.conn.SetReadDeadline(time.Now().Add(m.t.wait()))
+
+	return nil
+}
+
+// block 번호의 블록을 그룹으로 보내기
+func (m *mcastSession) sendBlock(block uint16) error {
+	data, err := m.data(block)
+	if err != nil {
+		return fmt.Errorf("preparing data packet: %w", err)
+	}
+
+	m.block = block
+	m.tries = m.t.retries
+
+	return m.write(data, m.group)
+}
+
+// 체크섬을 계산하지 않았다면 src를 처음부터 읽어 계산
+// 블록을 순서 없이 여러 번 보내므로 보내면서 계산하지 않고 세션마다 한 번만 계산해서 모든 클라이언트가 같이 쓴다
+func (m *mcastSession) checksum() {
+	if m.summed {
+		return
+	}
+	m.summed = true
+
+	h := m.s.newChecksum()
+	if h == nil {
+		return
+	}
+
+	_, err := io.Copy(h, io.NewSectionReader(m.src, 0, m.size))
+	if err != nil {
+		log.Printf("[%s] checksum: %v", m.t.peer, err)
+		return
+	}
+
+	m.sum = checksumString(h)
+}
+
+// 가장 먼저 참여한 클라이언트를 마스터로 정하고 OACK로 알리기
+// 새 마스터는 놓친 첫 블록 앞까지 ACK한다
+// 남은 클라이언트가 없다면 세션을 닫고 false 리턴
+func (m *mcastSession) promote() bool {
+	s := m.s
+
+	s.mu.Lock()
+	if len(m.clients) == 0 {
+		if s.groups[m.key] == m {
+			delete(s.groups, m.key)
+		}
+		s.mu.Unlock()
+		return false
+	}
+	m.master = m.clients[0]
+	s.mu.Unlock()
+
+	log.Printf("[%s] new multicast master for %s", m.master.addr, m.t.peer)
+
+	b, err := OACK{"multicast": m.option(true)}.MarshalBinary()
+	if err == nil {
+		m.tries = m.t.retries
+		err = m.write(b, m.master.addr)
+	}
+	if err != nil {
+		m.stop(err)
+		return false
+	}
+
+	return true
+}
+
+// 남은 클라이언트를 모두 err로 끝내고 세션 닫기
+// 전송이 중단된 경우라면 클라이언트들에게 에러 패킷으로 알린다
+func (m *mcastSession) stop(err error) {
+	s := m.s
+
+	s.mu.Lock()
+	clients := m.clients
+	m.clients = nil
+	if s.groups[m.key] == m {
+		delete(s.groups, m.key)
+	}
+	s.mu.Unlock()
+
+	log.Printf("[%s] multicast session: %v", m.t.peer, err)
+
+	for _, c := range clients {
+		if m.t.aborted() {
+			m.sendErr(c.addr, ErrUnknown, "transfer aborted")
+		}
+
+		c.err = err
+		close(c.done)
+	}
+}
+
+// 마스터에게 첫 블록이나 OACK를 보낸 뒤 마스터의 ACK에 맞춰 블록을 그룹으로 보내기
+// 마지막 블록을 ACK한 클라이언트는 세션에서 빼고
+// 마스터가 끝나거나 응답하지 않으면 다음 클라이언트를 마스터로 정한다
+// 세션의 모든 클라이언트가 끝나면 리턴
+func (m *mcastSession) run() {
+	var (
+		t   = m.t
+		buf = make([]byte, DatagramSize)
+	)
+
+	for {
+		n, addr, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			if t.aborted() {
+				m.stop(errAborted)
+				return
+			}
+			if !isTimeout(err) {
+				m.stop(fmt.Errorf("waiting for ACK: %w", err))
+				return
+			}
+
+			t.stats.timeout()
+
+			// 재시도 횟수를 다 썼다면 마스터를 빼고 다음 클라이언트를 마스터로
+			if m.tries--; m.tries == 0 {
+				m.remove(m.master, errExhausted)
+			}
+			// 마스터가 세션에서 빠졌다면 다음 마스터가 이어받는다
+			if m.client(m.master.addr) != m.master {
+				if !m.promote() {
+					return
+				}
+				continue
+			}
+
+			t.stats.retransmit(1)
+			err = m.write(m.pkt, m.to)
+			if err != nil {
+				m.stop(err)
+				return
+			}
+			continue
+		}
+
+		c := m.client(addr)
+		if c == nil {
+			t.rejectTID(addr, buf[:n])
+			continue
+		}
+
+		pkt, _ := ParsePacket(buf[:n])
+		switch pkt := pkt.(type) {
+		case *Ack:
+			block := uint16(*pkt)
+
+			// 마지막 블록의 ACK라면 그 클라이언트는 다 받았다
+			if block == m.last {
+				m.checksum()
+				m.remove(c, nil)
+				if c == m.master && !m.promote() {
+					return
+				}
+				continue
+			}
+
+			// 마스터가 아닌 클라이언트의 ACK는 무시
+			if c != m.master {
+				t.stats.duplicate()
+				continue
+			}
+
+			// 블록을 보낸 뒤라면 방금 보낸 블록부터의 ACK만 받는다
+			// 늦거나 중복된 ACK에 응답하면 이후 블록이 두 번씩 오가게 되므로 무시하고
+			// 블록이 유실됐다면 타임아웃으로 다시 보낸다
+			// 마스터가 되기 전에 받아둔 블록이 있다면 그 뒤까지 한꺼번에 ACK하므로 거기서부터 이어간다
+			if m.to == m.group && block < m.block {
+				t.stats.duplicate()
+				continue
+			}
+
+			// 새 마스터가 OACK에 답한 ACK라면 놓친 첫 블록부터 다시 보내게 된다
+			if block > m.last {
+				log.Printf("[%s] bad ACK %d", addr, block)
+				continue
+			}
+
+			err = m.sendBlock(block + 1)
+			if err != nil {
+				m.stop(err)
+				return
+			}
+		case *Err:
+			m.remove(c, &peerError{code: pkt.Error, msg: pkt.Message})
+			if c == m.master && !m.promote() {
+				return
+			}
+		default:
+			log.Printf("[%s] bad packet", addr)
+		}
+	}
+}
+
+// 클라이언트가 받은 multicast 옵션 값 해석
+// 주소와 포트는 처음 OACK에만 있고 마스터가 바뀔 때 보내는 OACK에서는 비어있을 수 있다
+func parseMulticast(value string) (*net.UDPAddr, bool, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 3 || (fields[2] != "0" && fields[2] != "1") {
+		return nil, false, fmt.Errorf("invalid multicast %q", value)
+	}
+
+	master := fields[2] == "1"
+	if fields[0] == "" && fields[1] == "" {
+		return nil, master, nil
+	}
+
+	ip := net.ParseIP(fields[0])
+	port, err := strconv.Atoi(fields[1])
+	if ip == nil || !ip.IsMulticast() || err != nil || port < 1 || port > 65535 {
+		return nil, false, fmt.Errorf("invalid multicast %q", value)
+	}
+
+	return &net.UDPAddr{IP: ip, Port: port}, master, nil
+}
+
+// 그룹 소켓이나 전송 소켓에서 받은 패킷
+type mcastPacket struct {
+	data []byte
+	addr net.Addr
+	// 그룹 소켓에서 받았는지 여부
+	group bool
+	err   error
+}
+
+// conn에서 읽은 패킷을 out으로 보내기
+// 읽기에 실패하거나 quit가 닫히면 끝난다
+func readPackets(conn net.PacketConn, size int, group bool, out chan<- mcastPacket, quit <-chan struct{}) {
+	for {
+		buf := make([]byte, size)
+		n, addr, err := conn.ReadFrom(buf)
+
+		select {
+		case out <- mcastPacket{data: buf[:n], addr: addr, group: group, err: err}:
+		case <-quit:
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// multicast 옵션을 수락한 서버에게서 그룹과 전송 소켓으로 블록을 받아 w에 기록
+// 순서가 어긋난 블록은 앞 블록을 받을 때까지 가지고 있다가 기록하고
+// 마스터일 때만 순서대로 받은 마지막 블록까지 ACK한다
+func (c Client) receiveMulticast(t *transfer, value string, w io.Writer) error {
+	group, master, err := parseMulticast(value)
+	if err == nil && group == nil {
+		err = fmt.Errorf("invalid multicast %q", value)
+	}
+	if err != nil {
+		t.sendErr(ErrBadOption, err.Error())
+		return err
+	}
+
+	gconn, err := net.ListenMulticastUDP("udp", c.MulticastInterface, group)
+	if err != nil {
+		t.sendErr(ErrUnknown, "cannot join multicast group")
+		return err
+	}
+	defer func() { _ = gconn.Close() }()
+
+	// 두 소켓에서 읽은 패킷을 한 곳에서 처리
+	// 전송 소켓은 ctx가 취소되면 데드라인으로 깨어난다
+	var (
+		packets = make(chan mcastPacket)
+		quit    = make(chan struct{})
+		size    = 4 + t.opts.blockSize
+	)
+	defer close(quit)
+
+	// 요청을 보낼 때 설정한 데드라인 지우기
+	// 지운 뒤에 중단됐는지 확인해야 취소를 놓치지 않는다
+	_ = t.conn.SetReadDeadline(time.Time{})
+	if t.aborted() {
+		return errAborted
+	}
+
+	// 블록 크기보다 큰 데이터그램이 잘려서 온전한 블록처럼 보이지 않도록 1byte 크게 받는다
+	go readPackets(gconn, size+1, true, packets, quit)
+	go readPackets(t.conn, size+1, false, packets, quit)
+
+	var (
+		// 순서가 어긋나 아직 기록하지 못한 블록
+		pending = make(map[uint16][]byte)
+		// 순서대로 기록한 마지막 블록 번호와 마지막 블록 번호, 모르면 0
+		have, last uint16
+		// 다 받은 뒤 마지막 ACK가 유실됐을 때를 위해 기다리는 중인지 여부
+		done  bool
+		tries = t.retries
+		timer = time.NewTimer(t.timeout)
+	)
+	defer timer.Stop()
+
+	// 받은 만큼 ACK, 다 받았다면 마지막 블록 ACK
+	ack := func() error {
+		if done {
+			return t.ack(last)
+		}
+
+		return t.ack(have)
+	}
+
+	// 마스터라면 OACK에 대한 ACK로 전송 시작
+	if master {
+		err = ack()
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case p := <-packets:
+			if p.err != nil {
+				if t.aborted() {
+					return errAborted
+				}
+
+				return fmt.Errorf("waiting for DATA: %w", p.err)
+			}
+
+			// 세션의 TID가 아닌 곳에서 온 패킷 중
+			// 그룹으로 온 패킷은 다른 세션의 패킷이므로 무시
+			if !sameTID(p.addr, t.peer) {
+				if !p.group {
+					t.rejectTID(p.addr, p.data)
+				}
+				continue
+			}
+
+			pkt, _ := parsePacket(p.data, t.opts.blockSize)
+			switch pkt := pkt.(type) {
+			case *Data:
+				// 다 받은 뒤에 다시 온 마지막 블록은 마스터가 보낸 마지막 ACK가 유실된 것
+				// 다른 블록은 놓친 블록을 다시 받는 다른 클라이언트를 위한 것이므로 무시
+				if done {
+					if master && pkt.Block == last {
+						_ = ack()
+						resetTimer(timer, t.timeout)
+					}
+					continue
+				}
+
+				// 이미 받은 블록은 타이머를 다시 설정하지 않는다
+				// 마스터가 보낸 ACK가 유실되면 서버가 이 블록을 다시 보내므로 받은 만큼 다시 ACK
+				block := pkt.Block
+				if _, ok := pending[block]; block <= have || ok {
+					t.stats.duplicate()
+					if master && block <= have {
+						err = ack()
+						if err != nil {
+							return err
+						}
+					}
+					continue
+				}
+				pending[block] = p.data[4:]
+
+				tries = t.retries
+				resetTimer(timer, t.timeout)
+
+				// 블록 크기보다 작은 블록이 마지막 블록
+				if len(p.data) < size {
+					last = block
+				}
+
+				// 이어지는 블록들 기록
+				// 마스터는 순서대로 받은 블록이 늘었을 때만 ACK하고
+				// 유실된 블록은 서버의 타임아웃으로 다시 받는다
+				advanced := false
+				for b, ok := pending[have+1]; ok; b, ok = pending[have+1] {
+					_, err = w.Write(b)
+					if err != nil {
+						t.sendErr(writeErr(err))
+						return err
+					}
+
+					delete(pending, have+1)
+					have++
+					advanced = true
+				}
+
+				// 다 받았다면 마스터가 아니더라도 서버가 세션에서 뺄 수 있도록 마지막 블록 ACK
+				if last != 0 && have == last {
+					done = true
+					_ = ack()
+					continue
+				}
+
+				if master && advanced {
+					err = ack()
+					if err != nil {
+						return err
+					}
+				}
+			// 마스터가 바뀌면 서버가 OACK로 알려준다
+			case *OACK:
+				value, ok := (*pkt)["multicast"]
+				if !ok {
+					continue
+				}
+
+				_, master, err = parseMulticast(value)
+				if err != nil {
+					t.sendErr(ErrBadOption, err.Error())
+					return err
+				}
+
+				tries = t.retries
+				resetTimer(timer, t.timeout)
+
+				if master {
+					_ = ack()
+				}
+			// 다 받은 뒤라면 세션에서 이미 빠진 것이므로 끝
+			case *Err:
+				if done {
+					return nil
+				}
+
+				return &peerError{code: pkt.Error, msg: pkt.Message}
+			default:
+				log.Printf("[%s] bad packet", t.peer)
+			}
+		case <-timer.C:
+			// 다 받은 뒤 기다리는 동안 서버가 다시 보내지 않았다면 끝
+			if done {
+				return nil
+			}
+
+			t.stats.timeout()
+			if tries--; tries == 0 {
+				return errExhausted
+			}
+
+			// 마스터라면 받은 만큼 다시 ACK
+			if master {
+				t.stats.retransmit(1)
+				_ = ack()
+			}
+			timer.Reset(t.timeout)
+		}
+	}
+}
+
+// 기다리던 타이머를 멈추고 d 뒤로 다시 설정
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
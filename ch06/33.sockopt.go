@@ -0,0 +1,64 @@
+//go:build unix
+
+// 멀티캐스트 패킷을 내보낼 인터페이스 설정
+package tftp
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// conn이 group으로 보내는 패킷을 ifi로 내보내도록 설정
+// IPv4 그룹이면 인터페이스의 IPv4 주소, IPv6 그룹이면 인터페이스 번호를 쓴다
+func setMulticastInterface(conn net.PacketConn, ifi *net.Interface, group net.IP) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errors.New("connection does not support socket options")
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var addr [4]byte
+	if group.To4() != nil {
+		ip, err := interfaceIPv4(ifi)
+		if err != nil {
+			return err
+		}
+		copy(addr[:], ip)
+	}
+
+	var opErr error
+	err = raw.Control(func(fd uintptr) {
+		if group.To4() != nil {
+			opErr = syscall.SetsockoptInet4Addr(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+			return
+		}
+
+		opErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, ifi.Index)
+	})
+	if err != nil {
+		return err
+	}
+
+	return opErr
+}
+
+// 인터페이스의 첫 IPv4 주소
+func interfaceIPv4(ifi *net.Interface) (net.IP, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			return n.IP.To4(), nil
+		}
+	}
+
+	return nil, errors.New("no IPv4 address on " + ifi.Name)
+}
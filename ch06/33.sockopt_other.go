@@ -0,0 +1,14 @@
+//go:build !unix
+
+// 멀티캐스트 패킷을 내보낼 인터페이스 설정
+package tftp
+
+import (
+	"errors"
+	"net"
+)
+
+// unix가 아닌 시스템에서는 인터페이스를 정할 수 없으므로 시스템 기본값을 쓴다
+func setMulticastInterface(net.PacketConn, *net.Interface, net.IP) error {
+	return errors.New("multicast interface is not supported on this system")
+}
@@ -0,0 +1,448 @@
+// 33 멀티캐스트 옵션 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 루프백 인터페이스에서 쓸 멀티캐스트 그룹
+// 리눅스가 아니거나 그룹에 참여할 수 없는 환경이면 테스트 건너뛰기
+func loopbackGroup(t *testing.T) (*net.Interface, *net.UDPAddr) {
+	t.Helper()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("loopback multicast test requires linux")
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	var lo *net.Interface
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 && ifaces[i].Flags&net.FlagUp != 0 {
+			lo = &ifaces[i]
+			break
+		}
+	}
+	if lo == nil {
+		t.Skip("no loopback interface")
+	}
+
+	// 비어있는 포트를 그룹 포트로 사용
+	probe, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	_ = probe.Close()
+
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 0, 69), Port: port}
+
+	conn, err := net.ListenMulticastUDP("udp4", lo, group)
+	if err != nil {
+		t.Skipf("cannot join multicast group: %v", err)
+	}
+	_ = conn.Close()
+
+	return lo, group
+}
+
+// 그룹과 유니캐스트로 보낸 데이터 패킷 수를 세는 소켓
+type countingConn struct {
+	*net.UDPConn
+	group, unicast *atomic.Int64
+}
+
+func (c countingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if len(p) >= 2 && OpCode(p[0])<<8|OpCode(p[1]) == OpData {
+		if udp, ok := addr.(*net.UDPAddr); ok && udp.IP.IsMulticast() {
+			c.group.Add(1)
+		} else {
+			c.unicast.Add(1)
+		}
+	}
+
+	return c.UDPConn.WriteTo(p, addr)
+}
+
+// 루프백 멀티캐스트로 주고받는 서버와 클라이언트
+type multicastTest struct {
+	server *Server
+	addr   net.Addr
+	client Client
+	// 그룹과 유니캐스트로 보낸 데이터 패킷 수
+	group, unicast atomic.Int64
+}
+
+// 보낼 내용을 정한 s에 멀티캐스트 설정을 더해서 띄우고 멀티캐스트를 요청하는 클라이언트 준비
+func newMulticastTest(t *testing.T, s *Server) *multicastTest {
+	t.Helper()
+
+	lo, group := loopbackGroup(t)
+	m := &multicastTest{server: s}
+
+	s.Timeout = 200 * time.Millisecond
+	s.Retries = 20
+	s.Multicast = group
+	s.MulticastInterface = lo
+	s.ListenPacket = func(network, address string) (net.PacketConn, error) {
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		return countingConn{UDPConn: conn.(*net.UDPConn), group: &m.group, unicast: &m.unicast}, nil
+	}
+	m.addr = startServer(t, m.server)
+
+	m.client = Client{
+		Timeout:            200 * time.Millisecond,
+		Retries:            20,
+		Options:            map[string]string{"multicast": "", "tsize": "0"},
+		MulticastInterface: lo,
+	}
+
+	return m
+}
+
+// 서버의 멀티캐스트 세션에 참여 중인 클라이언트 수
+func (m *multicastTest) sessionClients() int {
+	m.server.mu.Lock()
+	defer m.server.mu.Unlock()
+
+	n := 0
+	for _, session := range m.server.groups {
+		n += len(session.clients)
+	}
+
+	return n
+}
+
+func TestMulticastGet(t *testing.T) {
+	const blocks = 50
+
+	payload := make([]byte, blocks*BlockSize+100)
+	_, _ = rand.Read(payload)
+
+	m := newMulticastTest(t, &Server{Payload: payload})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var received bytes.Buffer
+			n, err := m.client.Get(context.Background(), m.addr.String(), "payload", &received)
+			if err != nil {
+				t.Errorf("client %d: %v", i, err)
+				return
+			}
+
+			if !bytes.Equal(payload, received.Bytes()) || n != int64(len(payload)) {
+				t.Errorf("client %d: expected %d bytes; actual %d bytes", i, len(payload), received.Len())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 모든 블록은 그룹으로만 보내야 한다
+	if n := m.unicast.Load(); n != 0 {
+		t.Errorf("expected no unicast data packets; actual %d", n)
+	}
+	// 동시에 요청한 클라이언트들은 한 세션에서 받으므로 각 블록을 그룹으로 한 번씩만 보낸다
+	// 타임아웃으로 다시 보낸 블록 몇 개는 허용
+	if n := m.group.Load(); n < blocks+1 || n > blocks+1+5 {
+		t.Errorf("expected about %d group data packets; actual %d", blocks+1, n)
+	}
+}
+
+func TestMulticastHandler(t *testing.T) {
+	// 클라이언트마다 다른 내용을 보내는 핸들러
+	// 같은 파일명이라도 한 세션으로 묶으면 다른 클라이언트의 내용을 받게 된다
+	content := func(addr net.Addr) []byte {
+		return bytes.Repeat([]byte(addr.String()+"\n"), 100)
+	}
+	m := newMulticastTest(t, &Server{
+		Handler: HandlerFunc(func(addr net.Addr, rrq ReadReq) (io.ReadCloser, *Err) {
+			return ReaderAtHandler(bytes.NewReader(content(addr))).ServeTFTP(addr, rrq)
+		}),
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			addr := conn.LocalAddr()
+			_ = conn.Close()
+
+			// 핸들러가 클라이언트 주소로 내용을 정하므로 미리 정한 주소로 요청
+			c := m.client
+			c.ListenPacket = func(network, _ string) (net.PacketConn, error) {
+				return net.ListenPacket(network, addr.String())
+			}
+
+			var received bytes.Buffer
+			_, err = c.Get(context.Background(), m.addr.String(), "pxelinux.cfg/default", &received)
+			if err != nil {
+				t.Errorf("client %d: %v", i, err)
+				return
+			}
+
+			if expected := content(addr); !bytes.Equal(expected, received.Bytes()) {
+				t.Errorf("client %d: expected %d bytes of its own content; actual %q", i, len(expected), received.Bytes())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Handler가 있다면 multicast 옵션을 거절하고 유니캐스트로 보낸다
+	if n := m.group.Load(); n != 0 {
+		t.Errorf("expected no group data packets; actual %d", n)
+	}
+}
+
+// 블록을 몇 개 받은 뒤 release가 닫힐 때까지 멈추는 io.Writer
+type stallWriter struct {
+	bytes.Buffer
+	after   int
+	stalled chan struct{}
+	release chan struct{}
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	if w.Len() >= w.after && w.stalled != nil {
+		close(w.stalled)
+		w.stalled = nil
+		<-w.release
+	}
+
+	return w.Buffer.Write(p)
+}
+
+func TestMulticastLateJoin(t *testing.T) {
+	const blocks = 40
+
+	payload := make([]byte, blocks*BlockSize+10)
+	_, _ = rand.Read(payload)
+
+	m := newMulticastTest(t, &Server{Payload: payload})
+
+	// 마스터가 10블록을 받은 뒤 멈춘 사이에 두 번째 클라이언트가 참여
+	master := &stallWriter{after: 10 * BlockSize, stalled: make(chan struct{}), release: make(chan struct{})}
+	stalled := master.stalled
+
+	masterErr := make(chan error, 1)
+	go func() {
+		_, err := m.client.Get(context.Background(), m.addr.String(), "payload", master)
+		masterErr <- err
+	}()
+
+	select {
+	case <-stalled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("master did not start")
+	}
+
+	late := make(chan error, 1)
+	var received bytes.Buffer
+	go func() {
+		_, err := m.client.Get(context.Background(), m.addr.String(), "payload", &received)
+		late <- err
+	}()
+
+	// 두 번째 클라이언트가 세션에 참여할 때까지 기다린 뒤 마스터 재개
+	for deadline := time.Now().Add(5 * time.Second); m.sessionClients() < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			close(master.release)
+			t.Fatal("second client did not join the session")
+		}
+	}
+	close(master.release)
+
+	if err := <-masterErr; err != nil {
+		t.Fatalf("master: %v", err)
+	}
+	if !bytes.Equal(payload, master.Bytes()) {
+		t.Errorf("master: expected %d bytes; actual %d bytes", len(payload), master.Len())
+	}
+
+	// 참여하기 전에 놓친 블록은 마스터가 된 뒤에 다시 받는다
+	if err := <-late; err != nil {
+		t.Fatalf("late client: %v", err)
+	}
+	if !bytes.Equal(payload, received.Bytes()) {
+		t.Errorf("late client: expected %d bytes; actual %d bytes", len(payload), received.Len())
+	}
+
+	if n := m.unicast.Load(); n != 0 {
+		t.Errorf("expected no unicast data packets; actual %d", n)
+	}
+	// 놓친 블록만 다시 보냈다면 두 번 보낸 것보다 적어야 한다
+	if n := m.group.Load(); n >= 2*(blocks+1) {
+		t.Errorf("expected fewer than %d group data packets; actual %d", 2*(blocks+1), n)
+	}
+}
+
+// block번 ACK를 처음 한 번만 보내지 않는 소켓
+type dropAckConn struct {
+	net.PacketConn
+	block   uint16
+	dropped atomic.Bool
+}
+
+func (c *dropAckConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	var ack Ack
+	if ack.UnmarshalBinary(p) == nil && uint16(ack) == c.block && c.dropped.CompareAndSwap(false, true) {
+		return len(p), nil
+	}
+
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func TestMulticastLostAck(t *testing.T) {
+	const blocks = 20
+
+	payload := make([]byte, blocks*BlockSize+10)
+	_, _ = rand.Read(payload)
+
+	m := newMulticastTest(t, &Server{Payload: payload})
+
+	// 그룹 패킷은 실제 멀티캐스트 소켓으로 받으므로 lossy 네트워크 대신
+	// 마스터의 전송 소켓에서 ACK 하나를 버린다
+	drop := &dropAckConn{block: 5}
+	c := m.client
+	c.ListenPacket = func(network, address string) (net.PacketConn, error) {
+		conn, err := net.ListenPacket(network, address)
+		drop.PacketConn = conn
+		return drop, err
+	}
+	// 서버가 같은 블록을 다시 보내는 동안 마스터의 타임아웃은 오지 않는다
+	c.Timeout = time.Second
+
+	var received bytes.Buffer
+	_, err := c.Get(context.Background(), m.addr.String(), "payload", &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !drop.dropped.Load() {
+		t.Fatal("expected a dropped ACK")
+	}
+	if !bytes.Equal(payload, received.Bytes()) {
+		t.Errorf("expected %d bytes; actual %d bytes", len(payload), received.Len())
+	}
+
+	// 다시 받은 블록에 바로 ACK하므로 유실된 ACK 하나에 블록 하나만 더 보낸다
+	if n := m.group.Load(); n != blocks+2 {
+		t.Errorf("expected %d group data packets; actual %d", blocks+2, n)
+	}
+}
+
+// 블록을 limit bytes 넘게 받으면 에러를 리턴하는 io.Writer
+type failAfterWriter struct {
+	*stallWriter
+	limit int
+}
+
+func (w failAfterWriter) Write(p []byte) (int, error) {
+	if w.Len() >= w.limit {
+		return 0, errors.New("disk full")
+	}
+
+	return w.stallWriter.Write(p)
+}
+
+func TestMulticastMasterAbort(t *testing.T) {
+	const blocks = 40
+
+	payload := make([]byte, blocks*BlockSize+10)
+	_, _ = rand.Read(payload)
+
+	m := newMulticastTest(t, &Server{Payload: payload})
+
+	// 마스터는 10블록을 받고 멈춘 사이에 두 번째 클라이언트가 참여하고
+	// 20블록을 받은 뒤 중단하므로 두 번째 클라이언트는 11번부터 받아둔 채로 마스터가 된다
+	stall := &stallWriter{after: 10 * BlockSize, stalled: make(chan struct{}), release: make(chan struct{})}
+	stalled := stall.stalled
+
+	masterErr := make(chan error, 1)
+	go func() {
+		_, err := m.client.Get(context.Background(), m.addr.String(), "payload", failAfterWriter{stall, 20 * BlockSize})
+		masterErr <- err
+	}()
+
+	select {
+	case <-stalled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("master did not start")
+	}
+
+	late := make(chan error, 1)
+	var received bytes.Buffer
+	go func() {
+		_, err := m.client.Get(context.Background(), m.addr.String(), "payload", &received)
+		late <- err
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); m.sessionClients() < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			close(stall.release)
+			t.Fatal("second client did not join the session")
+		}
+	}
+	close(stall.release)
+
+	if err := <-masterErr; err == nil {
+		t.Fatal("expected master to fail")
+	}
+
+	// 새 마스터는 놓친 블록을 받은 뒤 받아둔 블록까지 ACK하고 서버는 거기서부터 이어간다
+	if err := <-late; err != nil {
+		t.Fatalf("late client: %v", err)
+	}
+	if !bytes.Equal(payload, received.Bytes()) {
+		t.Errorf("late client: expected %d bytes; actual %d bytes", len(payload), received.Len())
+	}
+	if n := m.group.Load(); n >= 2*(blocks+1) {
+		t.Errorf("expected fewer than %d group data packets; actual %d", 2*(blocks+1), n)
+	}
+}
+
+func TestMulticastFallback(t *testing.T) {
+	payload := make([]byte, 3*BlockSize+1)
+	_, _ = rand.Read(payload)
+
+	// 멀티캐스트를 설정하지 않은 서버는 옵션을 무시하고 유니캐스트로 보낸다
+	addr := startServer(t, &Server{Payload: payload})
+
+	client := Client{Timeout: time.Second, Options: map[string]string{"multicast": "", "blksize": "1024"}}
+
+	var received bytes.Buffer
+	_, err := client.Get(context.Background(), addr.String(), "payload", &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(payload, received.Bytes()) {
+		t.Errorf("expected %d bytes; actual %d bytes", len(payload), received.Len())
+	}
+}
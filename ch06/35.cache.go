@@ -0,0 +1,217 @@
+// 자주 요청하는 파일 내용을 메모리에 두는 캐시
+package tftp
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/fs"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 파일 시스템에서 읽은 파일 내용을 최대 bytes 수 안에서 메모리에 두는 캐시
+// 넘치면 가장 오래 쓰지 않은 파일부터 버리고 (LRU)
+// 요청마다 파일의 수정 시간과 크기를 확인해서 바뀐 파일은 다시 읽는다
+// 캐시 하나는 파일 시스템 하나에만 써야 한다
+type FileCache struct {
+	maxBytes int64
+
+	mu sync.Mutex
+	// 파일명별 캐시한 내용
+	entries map[string]*cacheEntry
+	// 최근에 쓴 순서, 앞이 가장 최근
+	lru *list.List
+	// 다른 요청이 읽고 있는 파일, 같은 파일을 동시에 여러 번 읽지 않도록 기다린다
+	loading map[string]*cacheLoad
+	// 캐시한 내용의 bytes 수 합계
+	size  int64
+	stats CacheStats
+}
+
+// 캐시 통계
+type CacheStats struct {
+	// 캐시에서 보낸 요청 수와 파일 시스템에서 읽은 요청 수
+	Hits   uint64
+	Misses uint64
+	// 공간이 모자라 버린 파일 수
+	Evictions uint64
+	// 캐시한 파일 수와 bytes 수
+	Files int
+	Bytes int64
+}
+
+// 캐시한 파일 하나
+type cacheEntry struct {
+	name    string
+	modTime time.Time
+	data    []byte
+	elem    *list.Element
+}
+
+// 읽고 있는 파일
+type cacheLoad struct {
+	modTime time.Time
+	size    int64
+	// 다 읽으면 닫는다
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// 최대 maxBytes만큼 파일 내용을 두는 캐시 생성
+func NewFileCache(maxBytes int64) *FileCache {
+	return &FileCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*cacheEntry),
+		lru:      list.New(),
+		loading:  make(map[string]*cacheLoad),
+	}
+}
+
+// 요청한 파일명으로 fsys에서 파일을 찾아 캐시를 거쳐 보내는 핸들러
+// 없는 파일이나 루트 밖을 가리키는 경로는 FSHandler와 같은 에러로 응답
+func (c *FileCache) Handler(fsys fs.FS) Handler {
+	return HandlerFunc(func(_ net.Addr, rrq ReadReq) (io.ReadCloser, *Err) {
+		r, err := c.open(fsys, rrq.Filename)
+		if err != nil {
+			return nil, errPacket(err)
+		}
+
+		return r, nil
+	})
+}
+
+// 지금까지의 캐시 통계
+func (c *FileCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Files = len(c.entries)
+	stats.Bytes = c.size
+
+	return stats
+}
+
+// 캐시에 있는 내용이 그대로라면 캐시에서, 아니라면 파일 시스템에서 읽기
+// 캐시에 들어가지 않는 큰 파일은 캐시하지 않고 파일을 그대로 연다
+func (c *FileCache) open(fsys fs.FS, filename string) (io.ReadCloser, error) {
+	name := strings.TrimLeft(filename, "/")
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrPermission}
+	}
+
+	// 캐시에 있다면 파일을 열지 않고 수정 시간과 크기만 확인
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+	}
+
+	c.mu.Lock()
+
+	if e, ok := c.entries[name]; ok {
+		if e.modTime.Equal(info.ModTime()) && int64(len(e.data)) == info.Size() {
+			c.lru.MoveToFront(e.elem)
+			c.stats.Hits++
+			c.mu.Unlock()
+
+			return bytesSection(e.data), nil
+		}
+
+		// 파일이 바뀌었으므로 버리고 다시 읽는다
+		c.remove(e)
+	}
+
+	// 다른 요청이 같은 파일을 읽고 있다면 다 읽길 기다렸다가 같이 쓴다
+	if l, ok := c.loading[name]; ok && l.modTime.Equal(info.ModTime()) && l.size == info.Size() {
+		c.stats.Hits++
+		c.mu.Unlock()
+
+		<-l.done
+		if l.err != nil {
+			return nil, l.err
+		}
+
+		return bytesSection(l.data), nil
+	}
+
+	c.stats.Misses++
+
+	// 캐시보다 큰 파일은 캐시하지 않는다
+	if info.Size() > c.maxBytes {
+		c.mu.Unlock()
+		return openFile(fsys, filename)
+	}
+
+	l := &cacheLoad{modTime: info.ModTime(), size: info.Size(), done: make(chan struct{})}
+	c.loading[name] = l
+	c.mu.Unlock()
+
+	l.data, l.err = readFile(fsys, filename)
+
+	c.mu.Lock()
+	if c.loading[name] == l {
+		delete(c.loading, name)
+	}
+	// 읽는 사이 파일이 바뀌어 크기가 다르다면 캐시하지 않는다
+	if l.err == nil && int64(len(l.data)) == l.size {
+		c.add(name, l.modTime, l.data)
+	}
+	c.mu.Unlock()
+
+	close(l.done)
+
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	return bytesSection(l.data), nil
+}
+
+// 파일 내용 전부 읽기
+func readFile(fsys fs.FS, filename string) ([]byte, error) {
+	f, err := openFile(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return io.ReadAll(f)
+}
+
+// 캐시에 넣고 넘치는 만큼 오래된 파일 버리기, c.mu를 잡고 호출해야 한다
+func (c *FileCache) add(name string, modTime time.Time, data []byte) {
+	if e, ok := c.entries[name]; ok {
+		c.remove(e)
+	}
+
+	e := &cacheEntry{name: name, modTime: modTime, data: data}
+	e.elem = c.lru.PushFront(e)
+	c.entries[name] = e
+	c.size += int64(len(data))
+
+	for c.size > c.maxBytes {
+		oldest := c.lru.Back().Value.(*cacheEntry)
+		c.remove(oldest)
+		c.stats.Evictions++
+	}
+}
+
+// 캐시에서 빼기, c.mu를 잡고 호출해야 한다
+func (c *FileCache) remove(e *cacheEntry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.name)
+	c.size -= int64(len(e.data))
+}
+
+// 메모리에 있는 내용을 보내는 io.ReadCloser
+// 여러 전송이 같은 내용을 읽어도 서로의 위치에 영향을 주지 않는다
+func bytesSection(data []byte) io.ReadCloser {
+	return sizedSection{section{io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data)))}}
+}
@@ -0,0 +1,128 @@
+// 35 파일 캐시 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// 캐시에서 파일을 열어 내용 전부 읽기
+func readCached(t *testing.T, c *FileCache, fsys fstest.MapFS, name string) []byte {
+	t.Helper()
+
+	r, err := c.open(fsys, name)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	defer func() { _ = r.Close() }()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	return b
+}
+
+func TestFileCache(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a":   {Data: bytes.Repeat([]byte("a"), 100), ModTime: modTime},
+		"b":   {Data: bytes.Repeat([]byte("b"), 100), ModTime: modTime},
+		"c":   {Data: bytes.Repeat([]byte("c"), 100), ModTime: modTime},
+		"big": {Data: bytes.Repeat([]byte("x"), 1000), ModTime: modTime},
+	}
+
+	c := NewFileCache(250)
+
+	expect := func(desc string, expected CacheStats) {
+		t.Helper()
+
+		if actual := c.Stats(); actual != expected {
+			t.Errorf("%s: expected %+v; actual %+v", desc, expected, actual)
+		}
+	}
+
+	readCached(t, c, fsys, "a")
+	if b := readCached(t, c, fsys, "/a"); !bytes.Equal(b, fsys["a"].Data) {
+		t.Errorf("expected cached a; actual %q", b)
+	}
+	expect("hit", CacheStats{Hits: 1, Misses: 1, Files: 1, Bytes: 100})
+
+	// 가장 오래 쓰지 않은 a를 버린다
+	readCached(t, c, fsys, "b")
+	readCached(t, c, fsys, "c")
+	expect("evict", CacheStats{Hits: 1, Misses: 3, Evictions: 1, Files: 2, Bytes: 200})
+
+	readCached(t, c, fsys, "a")
+	expect("reload", CacheStats{Hits: 1, Misses: 4, Evictions: 2, Files: 2, Bytes: 200})
+
+	// 캐시보다 큰 파일은 캐시하지 않는다
+	if b := readCached(t, c, fsys, "big"); !bytes.Equal(b, fsys["big"].Data) {
+		t.Errorf("expected big file; actual %d bytes", len(b))
+	}
+	expect("big", CacheStats{Hits: 1, Misses: 5, Evictions: 2, Files: 2, Bytes: 200})
+
+	// 수정 시간이 바뀐 파일은 다시 읽는다
+	fsys["a"] = &fstest.MapFile{Data: bytes.Repeat([]byte("A"), 100), ModTime: modTime.Add(time.Second)}
+	if b := readCached(t, c, fsys, "a"); !bytes.Equal(b, fsys["a"].Data) {
+		t.Errorf("expected modified a; actual %q", b)
+	}
+	expect("modified", CacheStats{Hits: 1, Misses: 6, Evictions: 2, Files: 2, Bytes: 200})
+
+	readCached(t, c, fsys, "a")
+	expect("modified hit", CacheStats{Hits: 2, Misses: 6, Evictions: 2, Files: 2, Bytes: 200})
+}
+
+func TestServerCache(t *testing.T) {
+	kernel := bytes.Repeat([]byte("vmlinuz"), 1000)
+
+	cache := NewFileCache(1 << 20)
+	addr := startServer(t, &Server{
+		FS:      fstest.MapFS{"boot/vmlinuz": {Data: kernel}},
+		Cache:   cache,
+		Timeout: time.Second,
+	})
+
+	// 동시에 요청해도 파일은 한 번만 읽는다
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			actual, errPkt := download(t, addr, ReadReq{Filename: "/boot/vmlinuz"})
+			if errPkt != nil {
+				t.Errorf("client %d: unexpected error %d: %s", i, errPkt.Error, errPkt.Message)
+				return
+			}
+
+			if !bytes.Equal(kernel, actual) {
+				t.Errorf("client %d: expected %d bytes; actual %d bytes", i, len(kernel), len(actual))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Hits != 7 {
+		t.Errorf("expected 1 miss and 7 hits; actual %+v", stats)
+	}
+
+	for _, c := range []struct {
+		filename string
+		code     ErrCode
+	}{
+		{"missing.bin", ErrNotFound},
+		{"boot", ErrNotFound},
+		{"../etc/passwd", ErrAccessViolation},
+	} {
+		_, errPkt := download(t, addr, ReadReq{Filename: c.filename})
+		if errPkt == nil || errPkt.Error != c.code {
+			t.Errorf("%s: expected error %d; actual %v", c.filename, c.code, errPkt)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+// 01 패킷 해석 검증과 퍼즈 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 패킷을 해석하는 타입
+type unmarshaler interface {
+	UnmarshalBinary([]byte) error
+}
+
+// 잘못된 패킷이면 ErrInvalidPacket을 감싼 op의 PacketError여야 한다
+func expectInvalid(t *testing.T, desc string, op OpCode, err error) {
+	t.Helper()
+
+	if !errors.Is(err, ErrInvalidPacket) {
+		t.Errorf("%s: expected ErrInvalidPacket; actual %v", desc, err)
+		return
+	}
+
+	var pErr *PacketError
+	if !errors.As(err, &pErr) || pErr.Op != op {
+		t.Errorf("%s: expected %s packet error; actual %v", desc, op, err)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, c := range []struct {
+		desc string
+		op   OpCode
+		pkt  unmarshaler
+		p    string
+	}{
+		{"empty RRQ", OpRRQ, new(ReadReq), ""},
+		{"truncated RRQ opcode", OpRRQ, new(ReadReq), "\x00"},
+		{"WRQ as RRQ", OpRRQ, new(ReadReq), "\x00\x02file\x00octet\x00"},
+		{"unterminated filename", OpRRQ, new(ReadReq), "\x00\x01file"},
+		{"empty filename", OpRRQ, new(ReadReq), "\x00\x01\x00octet\x00"},
+		{"unterminated mode", OpRRQ, new(ReadReq), "\x00\x01file\x00octet"},
+		{"unsupported mode", OpRRQ, new(ReadReq), "\x00\x01file\x00mail\x00"},
+		{"missing option value", OpWRQ, new(WriteReq), "\x00\x02file\x00octet\x00blksize\x00"},
+		{"data after padding", OpWRQ, new(WriteReq), "\x00\x02file\x00octet\x00\x00x"},
+		{"truncated DATA opcode", OpData, new(Data), "\x00"},
+		{"truncated block number", OpData, new(Data), "\x00\x03\x00"},
+		{"ACK as DATA", OpData, new(Data), "\x00\x04\x00\x01"},
+		{"truncated ACK", OpAck, new(Ack), "\x00\x04\x00"},
+		{"long ACK", OpAck, new(Ack), "\x00\x04\x00\x01\x00"},
+		{"empty ERROR", OpErr, new(Err), ""},
+		{"truncated ERROR opcode", OpErr, new(Err), "\x00"},
+		{"truncated error code", OpErr, new(Err), "\x00\x05\x00"},
+		{"unterminated message", OpErr, new(Err), "\x00\x05\x00\x01missing"},
+		{"data after message", OpErr, new(Err), "\x00\x05\x00\x01missing\x00x"},
+		{"empty OACK", OpOAck, new(OACK), "\x00\x06"},
+		{"unterminated OACK option", OpOAck, new(OACK), "\x00\x06blksize"},
+	} {
+		expectInvalid(t, c.desc, c.op, c.pkt.UnmarshalBinary([]byte(c.p)))
+	}
+
+	// 끝을 0으로 채운 패킷은 받는다
+	var errPkt Err
+	if err := errPkt.UnmarshalBinary([]byte("\x00\x05\x00\x01not found\x00\x00\x00")); err != nil {
+		t.Errorf("padded ERROR: %v", err)
+	}
+	if errPkt.Error != ErrNotFound || errPkt.Message != "not found" {
+		t.Errorf("padded ERROR: unexpected %+v", errPkt)
+	}
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	for _, c := range []struct {
+		desc string
+		op   OpCode
+		pkt  interface{ MarshalBinary() ([]byte, error) }
+	}{
+		{"empty filename", OpRRQ, ReadReq{}},
+		{"NUL in filename", OpWRQ, WriteReq{Filename: "a\x00b"}},
+		{"NUL in mode", OpRRQ, ReadReq{Filename: "a", Mode: "oc\x00tet"}},
+		{"empty option name", OpRRQ, ReadReq{Filename: "a", Options: map[string]string{"": "1"}}},
+		{"NUL in option value", OpOAck, OACK{"blksize": "1\x002"}},
+		{"NUL in message", OpErr, Err{Message: "a\x00b"}},
+	} {
+		_, err := c.pkt.MarshalBinary()
+		expectInvalid(t, c.desc, c.op, err)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	for _, c := range []struct {
+		p        string
+		expected Packet
+	}{
+		{"\x00\x01file\x00OCTET\x00tsize\x000\x00", &ReadReq{Filename: "file", Mode: ModeOctet, Options: map[string]string{"tsize": "0"}}},
+		{"\x00\x02file\x00netascii\x00", &WriteReq{Filename: "file", Mode: ModeNetASCII}},
+		{"\x00\x04\x01\x02", func() *Ack { a := Ack(0x0102); return &a }()},
+		{"\x00\x05\x00\x01not found\x00", &Err{Error: ErrNotFound, Message: "not found"}},
+		{"\x00\x06blksize\x001428\x00", &OACK{"blksize": "1428"}},
+	} {
+		pkt, err := ParsePacket([]byte(c.p))
+		if err != nil {
+			t.Errorf("%q: %v", c.p, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(c.expected, pkt) {
+			t.Errorf("%q: expected %+v; actual %+v", c.p, c.expected, pkt)
+		}
+	}
+
+	// 블록 크기를 모르므로 기본 블록 크기보다 큰 DATA도 받는다
+	payload := bytes.Repeat([]byte{'x'}, 1428)
+	pkt, err := ParsePacket(append([]byte{0, 3, 0, 7}, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataPkt, ok := pkt.(*Data)
+	if !ok {
+		t.Fatalf("expected *Data; actual %T", pkt)
+	}
+	if b, _ := io.ReadAll(dataPkt.Payload); dataPkt.Block != 7 || !bytes.Equal(payload, b) {
+		t.Errorf("expected block 7 with %d bytes; actual block %d with %d bytes", len(payload), dataPkt.Block, len(b))
+	}
+
+	// 협상한 블록 크기보다 크면 거부
+	_, err = parsePacket(append([]byte{0, 3, 0, 7}, payload...), 1024)
+	expectInvalid(t, "oversized DATA", OpData, err)
+
+	for _, p := range []string{"", "\x00", "\x00\x07data", "\xff\xff"} {
+		_, err = ParsePacket([]byte(p))
+		expectInvalid(t, fmt.Sprintf("%q", p), 0, err)
+	}
+
+	// 알맞은 opcode의 잘못된 패킷은 그 패킷의 에러
+	_, err = ParsePacket([]byte("\x00\x04\x00"))
+	expectInvalid(t, "truncated ACK", OpAck, err)
+}
+
+// 요청 패킷을 만들고 다시 해석해서 같은 요청인지 확인
+func FuzzRequest(f *testing.F) {
+	f.Add("pxelinux.0", "octet", "blksize", "1428")
+	f.Add("boot/vmlinuz", "NetASCII", "TSize", "0")
+	f.Add("file", "", "", "")
+	f.Add("a\x00b", "mail", "x\x00", "\x00")
+
+	f.Fuzz(func(t *testing.T, filename, mode, name, value string) {
+		var options map[string]string
+		if name != "" || value != "" {
+			options = map[string]string{name: value}
+		}
+
+		for _, op := range []OpCode{OpRRQ, OpWRQ} {
+			var (
+				b   []byte
+				err error
+				req unmarshaler
+			)
+			if op == OpRRQ {
+				b, err = ReadReq{Filename: filename, Mode: mode, Options: options}.MarshalBinary()
+				req = new(ReadReq)
+			} else {
+				b, err = WriteReq{Filename: filename, Mode: mode, Options: options}.MarshalBinary()
+				req = new(WriteReq)
+			}
+			if err != nil {
+				expectInvalid(t, "marshal", op, err)
+				continue
+			}
+
+			err = req.UnmarshalBinary(b)
+
+			// 해석하면 mode와 옵션 이름은 소문자가 된다
+			expectedMode := strings.ToLower(mode)
+			if expectedMode == "" {
+				expectedMode = ModeOctet
+			}
+			if expectedMode != ModeOctet && expectedMode != ModeNetASCII {
+				expectInvalid(t, "unsupported mode", op, err)
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s %q: %v", op, b, err)
+			}
+
+			var expectedOptions map[string]string
+			if options != nil {
+				expectedOptions = map[string]string{strings.ToLower(name): value}
+			}
+
+			var actual [3]any
+			switch r := req.(type) {
+			case *ReadReq:
+				actual = [3]any{r.Filename, r.Mode, r.Options}
+			case *WriteReq:
+				actual = [3]any{r.Filename, r.Mode, r.Options}
+			}
+
+			if expected := [3]any{filename, expectedMode, expectedOptions}; !reflect.DeepEqual(expected, actual) {
+				t.Fatalf("%s: expected %q; actual %q", op, expected, actual)
+			}
+		}
+	})
+}
+
+// 데이터 패킷을 만들고 다시 해석해서 같은 블록인지 확인
+func FuzzData(f *testing.F) {
+	f.Add(uint16(0), []byte("payload"), 0)
+	f.Add(uint16(65534), bytes.Repeat([]byte{1}, 600), 8)
+	f.Add(uint16(7), []byte{}, 1428)
+
+	f.Fuzz(func(t *testing.T, block uint16, payload []byte, size int) {
+		if size < 0 || size > MaxBlockSize {
+			size %= MaxBlockSize + 1
+			if size < 0 {
+				size = -size
+			}
+		}
+
+		dataPkt := Data{Block: block, Payload: bytes.NewReader(payload), Size: size}
+		b, err := dataPkt.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := Data{Size: size}
+		if err = actual.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%q: %v", b, err)
+		}
+
+		// MarshalBinary가 블록 번호를 1 늘린다
+		if actual.Block != block+1 {
+			t.Fatalf("expected block %d; actual %d", block+1, actual.Block)
+		}
+
+		expected := payload
+		if l := actual.blockSize(); len(expected) > l {
+			expected = expected[:l]
+		}
+		got, _ := io.ReadAll(actual.Payload)
+		if !bytes.Equal(expected, got) {
+			t.Fatalf("expected payload %q; actual %q", expected, got)
+		}
+	})
+}
+
+// ACK, 에러, OACK 패킷을 만들고 다시 해석해서 같은 패킷인지 확인
+func FuzzReply(f *testing.F) {
+	f.Add(uint16(1), "file not found", "blksize", "1428")
+	f.Add(uint16(65535), "", "tsize", "")
+	f.Add(uint16(0), "bad\x00message", "", "1")
+
+	f.Fuzz(func(t *testing.T, block uint16, msg, name, value string) {
+		b, err := Ack(block).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ack Ack
+		if err = ack.UnmarshalBinary(b); err != nil || ack != Ack(block) {
+			t.Fatalf("ACK %d: %v, %d", block, err, ack)
+		}
+
+		errPkt := Err{Error: ErrCode(block), Message: msg}
+		b, err = errPkt.MarshalBinary()
+		if err != nil {
+			expectInvalid(t, "marshal ERROR", OpErr, err)
+		} else {
+			var actual Err
+			if err = actual.UnmarshalBinary(b); err != nil || actual != errPkt {
+				t.Fatalf("ERROR %+v: %v, %+v", errPkt, err, actual)
+			}
+		}
+
+		oack := OACK{name: value}
+		b, err = oack.MarshalBinary()
+		if err != nil {
+			expectInvalid(t, "marshal OACK", OpOAck, err)
+			return
+		}
+
+		var actual OACK
+		if err = actual.UnmarshalBinary(b); err != nil {
+			t.Fatalf("OACK %q: %v", b, err)
+		}
+		if expected := (OACK{strings.ToLower(name): value}); !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("expected %q; actual %q", expected, actual)
+		}
+	})
+}
+
+// 아무 데이터나 해석해도 패닉하지 않고 잘못된 패킷은 PacketError로 거부해야 한다
+// 받아들인 패킷은 다시 만들어 해석해도 같은 내용이어야 한다
+func FuzzUnmarshal(f *testing.F) {
+	f.Add([]byte("\x00\x01file\x00octet\x00blksize\x001428\x00"))
+	f.Add([]byte("\x00\x02file\x00NETASCII\x00\x00\x00"))
+	f.Add([]byte("\x00\x03\x00\x01data"))
+	f.Add([]byte("\x00\x04\x00\x01"))
+	f.Add([]byte("\x00\x05\x00\x01not found\x00"))
+	f.Add([]byte("\x00\x06tsize\x00100\x00"))
+	f.Add([]byte("\x00"))
+
+	f.Fuzz(func(t *testing.T, p []byte) {
+		// opcode에 맞는 패킷으로 해석하거나 잘못된 패킷으로 거부해야 한다
+		pkt, err := ParsePacket(p)
+		switch {
+		case err != nil:
+			if !errors.Is(err, ErrInvalidPacket) {
+				t.Fatalf("%q: expected ErrInvalidPacket; actual %v", p, err)
+			}
+		case pkt.Op() != OpCode(p[0])<<8|OpCode(p[1]):
+			t.Fatalf("%q: unexpected %s packet", p, pkt.Op())
+		}
+
+		// 데이터 패킷은 같은 블록 번호와 payload로 다시 만들면 같은 bytes여야 한다
+		var dataPkt Data
+		if err := dataPkt.UnmarshalBinary(p); err != nil {
+			expectInvalid(t, "unmarshal", OpData, err)
+		} else {
+			dataPkt.Block--
+			b, err := dataPkt.MarshalBinary()
+			if err != nil || !bytes.Equal(p, b) {
+				t.Fatalf("DATA %q: remarshaled %q, %v", p, b, err)
+			}
+		}
+
+		for _, c := range []struct {
+			op OpCode
+			// 같은 종류의 빈 패킷 생성
+			new func() unmarshaler
+		}{
+			{OpRRQ, func() unmarshaler { return new(ReadReq) }},
+			{OpWRQ, func() unmarshaler { return new(WriteReq) }},
+			{OpAck, func() unmarshaler { return new(Ack) }},
+			{OpErr, func() unmarshaler { return new(Err) }},
+			{OpOAck, func() unmarshaler { return new(OACK) }},
+		} {
+			pkt := c.new()
+			err := pkt.UnmarshalBinary(p)
+			if err != nil {
+				expectInvalid(t, "unmarshal", c.op, err)
+				continue
+			}
+
+			b, err := pkt.(interface{ MarshalBinary() ([]byte, error) }).MarshalBinary()
+			if err != nil {
+				t.Fatalf("%s %q: marshal: %v", c.op, p, err)
+			}
+
+			again := c.new()
+			if err = again.UnmarshalBinary(b); err != nil {
+				t.Fatalf("%s %q: unmarshal %q: %v", c.op, p, b, err)
+			}
+
+			if !reflect.DeepEqual(pkt, again) {
+				t.Fatalf("%s %q: expected %+v; actual %+v", c.op, p, pkt, again)
+			}
+		}
+	})
+}
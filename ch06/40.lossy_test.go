@@ -0,0 +1,97 @@
+// 38 손실 네트워크에서 전송 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"tftp/lossy"
+	"time"
+)
+
+// 패킷 20%가 유실되고 일부는 중복, 지연, 순서가 바뀌어도 전송을 끝까지 마쳐야 한다
+func TestLossyTransfers(t *testing.T) {
+	payload := make([]byte, 40*1024+100)
+	_, _ = rand.Read(payload)
+
+	for i, window := range []string{"1", "4", "8"} {
+		n := lossy.NewNetwork(lossy.Config{
+			Loss:      0.2,
+			Duplicate: 0.05,
+			Reorder:   0.05,
+			Jitter:    time.Millisecond,
+			Seed:      int64(i + 1),
+		})
+
+		// 전송이 끝난 뒤에 늦게 도착한 중복 쓰기 요청은 새 전송을 시작하고
+		// 클라이언트가 ErrUnknownID로 거부하므로 그 전송이 닫으며 보낸 내용까지 담을 수 있도록 여유를 둔다
+		uploads := make(chan []byte, 8)
+		// 받는 쪽인 서버는 마지막 ACK가 유실되었을 때 클라이언트가 다시 보내는 블록을
+		// 여러 번 기다릴 수 있도록 클라이언트보다 오래 기다린다
+		addr := startMemServer(t, n, &Server{
+			Payload: payload,
+			Upload: func(string) (io.WriteCloser, error) {
+				return &chanWriter{done: uploads}, nil
+			},
+			Retries: 50,
+			Timeout: 100 * time.Millisecond,
+		})
+
+		options := map[string]string{"blksize": "1024", "windowsize": window}
+
+		t.Run(fmt.Sprintf("get windowsize %s", window), func(t *testing.T) {
+			actual := memDownload(t, n, addr, ReadReq{Filename: "payload", Options: options}, 20*time.Millisecond)
+			if !bytes.Equal(payload, actual) {
+				t.Errorf("expected %d bytes; actual %d bytes", len(payload), len(actual))
+			}
+		})
+
+		t.Run(fmt.Sprintf("put windowsize %s", window), func(t *testing.T) {
+			memUpload(t, n, addr, WriteReq{Filename: "upload", Options: options}, payload, 20*time.Millisecond)
+
+			for {
+				select {
+				case actual := <-uploads:
+					if bytes.Equal(payload, actual) {
+						return
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("upload not written")
+				}
+			}
+		})
+
+		if stats := n.Stats(); stats.Dropped == 0 || stats.Duplicated == 0 || stats.Reordered == 0 {
+			t.Errorf("windowsize %s: expected dropped, duplicated and reordered packets; actual %+v", window, stats)
+		}
+	}
+}
+
+// 손상된 패킷이 와도 패닉하거나 멈추지 않고 전송을 끝내거나 에러를 리턴해야 한다
+func TestCorruptTransfers(t *testing.T) {
+	payload := make([]byte, 20*BlockSize)
+	_, _ = rand.Read(payload)
+
+	n := lossy.NewNetwork(lossy.Config{Loss: 0.05, Corrupt: 0.05, Seed: 1})
+	addr := startMemServer(t, n, &Server{Payload: payload, Retries: 20, Timeout: 20 * time.Millisecond})
+
+	c := Client{Retries: 20, Timeout: 20 * time.Millisecond, ListenPacket: n.ListenPacket}
+
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := c.Get(ctx, addr.String(), "payload", io.Discard)
+		cancel()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			t.Fatalf("transfer %d did not finish: %v", i, err)
+		}
+	}
+
+	if n.Stats().Corrupted == 0 {
+		t.Error("expected corrupted packets")
+	}
+}
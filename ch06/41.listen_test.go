@@ -0,0 +1,103 @@
+// 02 여러 주소에서 요청 받기 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// 서버가 n개의 리스너로 요청을 받기 시작할 때까지 기다리고 리스너 주소 리턴
+func waitListeners(t *testing.T, s *Server, n int) []net.Addr {
+	t.Helper()
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if addrs := s.Addrs(); len(addrs) == n {
+			return addrs
+		}
+	}
+
+	t.Fatalf("expected %d listeners; actual %v", n, s.Addrs())
+	return nil
+}
+
+func TestListenAndServeMultiple(t *testing.T) {
+	// IPv6 루프백을 쓸 수 없는 환경이면 건너뛰기
+	probe, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	_ = probe.Close()
+
+	payload := bytes.Repeat([]byte("dual-stack"), 200)
+	s := &Server{Payload: payload, Timeout: time.Second}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServe("127.0.0.1:0", "[::1]:0") }()
+
+	addrs := waitListeners(t, s, 2)
+
+	c := Client{Timeout: time.Second}
+	for _, addr := range addrs {
+		var received bytes.Buffer
+		_, err := c.Get(context.Background(), addr.String(), "payload", &received)
+		if err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+
+		if !bytes.Equal(payload, received.Bytes()) {
+			t.Errorf("%s: expected %d bytes; actual %d bytes", addr, len(payload), received.Len())
+		}
+	}
+
+	// 진행 중인 전송이 끝나길 기다리며 종료
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 두 리스너의 전송이 같은 통계에 모인다
+	if stats := s.Stats(); stats.Transfers != 2 {
+		t.Errorf("expected 2 transfers; actual %+v", stats)
+	}
+
+	select {
+	case err = <-errc:
+		if err != ErrServerClosed {
+			t.Errorf("expected ErrServerClosed; actual %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ListenAndServe did not return")
+	}
+
+	if addrs := s.Addrs(); len(addrs) != 0 {
+		t.Errorf("expected no listeners; actual %v", addrs)
+	}
+}
+
+func TestListenAndServeError(t *testing.T) {
+	// 열 수 없는 주소가 하나라도 있다면 시작하지 않는다
+	s := &Server{Payload: []byte("payload")}
+	if err := s.ListenAndServe("127.0.0.1:0", "256.0.0.1:69"); err == nil {
+		t.Error("expected listen error")
+	}
+	if err := s.ListenAndServe(); err == nil {
+		t.Error("expected error without addresses")
+	}
+
+	// 한 리스너가 에러로 끝나면 나머지 리스너도 중단
+	s = &Server{}
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServe("127.0.0.1:0", "127.0.0.1:0") }()
+
+	select {
+	case err := <-errc:
+		if err == nil || err == ErrServerClosed {
+			t.Errorf("expected configuration error; actual %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ListenAndServe did not return")
+	}
+}
@@ -0,0 +1,104 @@
+//go:build unix
+
+// 권한이 필요한 포트를 연 뒤 서버 프로세스의 권한 낮추기
+package tftp
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// 프로세스를 username 사용자와 그룹으로 바꾸고 dir이 있다면 dir로 chroot
+// 사용자 정보는 chroot하기 전에 읽는다
+// 하나라도 실패하면 에러를 리턴하므로 호출한 쪽은 서버를 시작하지 말아야 한다
+// root는 chroot 밖으로 나갈 수 있으므로 root가 아닌 사용자 없이 chroot만 하지는 않는다
+func DropPrivileges(username, dir string) error {
+	if dir != "" && username == "" {
+		return fmt.Errorf("chroot %s: a non-root user is required", dir)
+	}
+
+	var (
+		uid, gid int
+		groups   []int
+	)
+
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return err
+		}
+
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return fmt.Errorf("user %s: invalid uid %q", username, u.Uid)
+		}
+		gid, err = strconv.Atoi(u.Gid)
+		if err != nil {
+			return fmt.Errorf("user %s: invalid gid %q", username, u.Gid)
+		}
+
+		// 사용자가 속한 보조 그룹, 읽을 수 없다면 기본 그룹만 쓴다
+		groups = []int{gid}
+		if ids, err := u.GroupIds(); err == nil {
+			groups = groups[:0]
+			for _, id := range ids {
+				g, err := strconv.Atoi(id)
+				if err != nil {
+					return fmt.Errorf("user %s: invalid group id %q", username, id)
+				}
+				groups = append(groups, g)
+			}
+		}
+
+		if dir != "" && uid == 0 {
+			return fmt.Errorf("chroot %s: user %s is root", dir, username)
+		}
+	}
+
+	// chroot는 root 권한이 필요하므로 사용자를 바꾸기 전에
+	if dir != "" {
+		err := syscall.Chroot(dir)
+		if err != nil {
+			return fmt.Errorf("chroot %s: %w", dir, err)
+		}
+
+		err = syscall.Chdir("/")
+		if err != nil {
+			return fmt.Errorf("chdir /: %w", err)
+		}
+	}
+
+	if username == "" {
+		return nil
+	}
+
+	// 그룹을 먼저 바꾸고 마지막에 사용자를 바꾼다
+	// 사용자를 먼저 바꾸면 그룹을 바꿀 권한이 없다
+	err := syscall.Setgroups(groups)
+	if err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+
+	err = syscall.Setgid(gid)
+	if err != nil {
+		return fmt.Errorf("setgid %d: %w", gid, err)
+	}
+
+	err = syscall.Setuid(uid)
+	if err != nil {
+		return fmt.Errorf("setuid %d: %w", uid, err)
+	}
+
+	// 바뀐 사용자와 그룹을 확인하고 root 권한을 되찾을 수 없는지 확인
+	if syscall.Getuid() != uid || syscall.Geteuid() != uid || syscall.Getgid() != gid || syscall.Getegid() != gid {
+		return fmt.Errorf("privileges not dropped: uid %d, euid %d, gid %d, egid %d",
+			syscall.Getuid(), syscall.Geteuid(), syscall.Getgid(), syscall.Getegid())
+	}
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("privileges regained after setuid %d", uid)
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+// 권한이 필요한 포트를 연 뒤 서버 프로세스의 권한 낮추기
+package tftp
+
+import "errors"
+
+// unix가 아닌 시스템에서는 사용자를 바꾸거나 chroot할 수 없다
+func DropPrivileges(username, dir string) error {
+	return errors.New("dropping privileges is not supported on this system")
+}
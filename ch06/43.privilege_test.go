@@ -0,0 +1,131 @@
+//go:build unix
+
+// 42 권한 낮추기 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 권한을 낮춘 프로세스는 되돌릴 수 없으므로 이 테스트 바이너리를 새 프로세스로 실행해서 확인
+const dropPrivilegesEnv = "TFTP_TEST_DROP_PRIVILEGES"
+
+func TestDropPrivileges(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("dropping privileges requires root")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+
+	dir := t.TempDir()
+	err := os.Chmod(dir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "pxelinux.0"), []byte("boot loader"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDropPrivilegesProcess$", "-test.v")
+	cmd.Env = append(os.Environ(), dropPrivilegesEnv+"="+dir)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil || !bytes.Contains(out, []byte("--- PASS: TestDropPrivilegesProcess")) {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
+
+// TestDropPrivileges가 실행하는 프로세스
+// 69번 포트를 연 뒤 nobody로 바꾸고 chroot한 디렉터리의 파일을 보낸다
+func TestDropPrivilegesProcess(t *testing.T) {
+	dir := os.Getenv(dropPrivilegesEnv)
+	if dir == "" {
+		t.Skip("run by TestDropPrivileges")
+	}
+
+	// 권한이 필요한 포트는 권한을 낮추기 전에 연다
+	conns, err := Listen("127.0.0.1:69")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conns[0].Close() }()
+
+	// chroot한 뒤에는 사용자 정보를 읽을 수 없으므로 미리 읽어둔다
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DropPrivileges("nobody", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := strconv.Itoa(os.Geteuid()); uid != nobody.Uid {
+		t.Fatalf("expected uid %s; actual %s", nobody.Uid, uid)
+	}
+
+	// root의 디렉터리에는 더 이상 쓸 수 없다
+	if err = os.WriteFile("/upload", nil, 0o644); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected permission error; actual %v", err)
+	}
+
+	// chroot했으므로 디렉터리 밖의 파일은 보이지 않는다
+	if _, err = os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected %s to be outside the root; actual %v", dir, err)
+	}
+
+	// 먼저 연 리스너로 chroot한 디렉터리의 파일 보내기
+	s := &Server{FS: os.DirFS("/"), Timeout: time.Second}
+	go func() { _ = s.Serve(context.Background(), conns[0]) }()
+
+	var received bytes.Buffer
+	_, err = Client{Timeout: time.Second}.Get(context.Background(), conns[0].LocalAddr().String(), "pxelinux.0", &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.String() != "boot loader" {
+		t.Errorf("expected boot loader; actual %q", received.String())
+	}
+}
+
+func TestDropPrivilegesUnknownUser(t *testing.T) {
+	// 사용자를 찾을 수 없다면 아무것도 바꾸지 않고 에러
+	uid := os.Getuid()
+	if err := DropPrivileges("tftp-no-such-user", "/nonexistent"); err == nil {
+		t.Fatal("expected unknown user error")
+	}
+	if os.Getuid() != uid {
+		t.Fatalf("expected uid %d; actual %d", uid, os.Getuid())
+	}
+}
+
+func TestDropPrivilegesChrootOnly(t *testing.T) {
+	// root로 남는 chroot는 경계가 되지 않으므로 사용자를 바꾸지 않는다면 chroot하지 않는다
+	dir := t.TempDir()
+	if err := DropPrivileges("", dir); err == nil {
+		t.Fatal("expected error without a user")
+	}
+
+	if u, err := user.LookupId("0"); err == nil {
+		if err = DropPrivileges(u.Username, dir); err == nil {
+			t.Fatalf("expected error with %s", u.Username)
+		}
+	}
+
+	// chroot했다면 dir이 보이지 않는다
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("expected no chroot: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+// 누가 어떤 파일을 주고받았는지 남기는 감사 로그
+package tftp
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// AuditRecord.Outcome 값
+const (
+	// 마지막 블록까지 주고받음
+	OutcomeCompleted = "completed"
+	// 클라이언트가 에러 패킷을 보내서 중단, 에러 코드는 AuditRecord.ErrCode
+	OutcomeClientError = "client error"
+	// 클라이언트가 응답하지 않아 재시도 횟수를 다 씀
+	OutcomeExhausted = "exhausted retries"
+	// 서버가 종료되면서 중단
+	OutcomeAborted = "aborted"
+	// 그 밖의 이유로 실패, 없는 파일이나 가득 찬 디스크 등
+	OutcomeFailed = "failed"
+)
+
+// 전송 하나가 끝날 때마다 Server.AuditLog에 JSON 한 줄로 기록하는 내용
+type AuditRecord struct {
+	// 전송이 끝난 시간
+	Time time.Time `json:"time"`
+	// 클라이언트 주소
+	Client string `json:"client"`
+	// RRQ 또는 WRQ
+	Op       string `json:"op"`
+	Filename string `json:"filename"`
+	Mode     string `json:"mode"`
+	// 서버가 수락한 옵션(OACK), 없으면 생략
+	Options map[string]string `json:"options,omitempty"`
+	// 보내거나 받은 bytes 수와 블록 수
+	// netascii mode라면 변환하기 전 파일의 bytes 수
+	Bytes  int64 `json:"bytes"`
+	Blocks int   `json:"blocks"`
+	// 걸린 시간(초)
+	Duration float64 `json:"duration"`
+	// 전송 결과, Outcome 상수 중 하나
+	Outcome string `json:"outcome"`
+	// Outcome이 OutcomeClientError일 때 클라이언트가 보낸 에러 코드
+	ErrCode *ErrCode `json:"error_code,omitempty"`
+	// 실패했다면 그 이유
+	Error string `json:"error,omitempty"`
+	// Bytes만큼 보내거나 받은 내용의 SHA-512/256 체크섬
+	// sha512 도구로 계산한 파일의 체크섬과 비교할 수 있다
+	Checksum string `json:"sha512_256,omitempty"`
+}
+
+// 전송이 끝난 이벤트로 감사 기록 만들기
+func newAuditRecord(ev TransferEvent) AuditRecord {
+	rec := AuditRecord{
+		Time:     ev.Start.Add(ev.Duration),
+		Client:   ev.Addr.String(),
+		Op:       ev.Op.String(),
+		Filename: ev.Filename,
+		Mode:     ev.Mode,
+		Options:  ev.Options,
+		Bytes:    ev.Bytes,
+		Blocks:   ev.Blocks,
+		Duration: ev.Duration.Seconds(),
+		Outcome:  OutcomeCompleted,
+		Checksum: ev.Checksum,
+	}
+	if ev.Err == nil {
+		return rec
+	}
+
+	rec.Error = ev.Err.Error()
+
+	var pe *peerError
+	switch {
+	case errors.As(ev.Err, &pe):
+		rec.Outcome = OutcomeClientError
+		rec.ErrCode = &pe.code
+	case errors.Is(ev.Err, errExhausted):
+		rec.Outcome = OutcomeExhausted
+	case errors.Is(ev.Err, errAborted):
+		rec.Outcome = OutcomeAborted
+	default:
+		rec.Outcome = OutcomeFailed
+	}
+
+	return rec
+}
+
+// AuditLog가 있다면 전송 결과를 JSON 한 줄로 덧붙이기
+// 여러 전송의 기록이 섞이지 않도록 한 줄을 한 번의 Write로 쓴다
+func (s *Server) audit(ev TransferEvent) {
+	if s.AuditLog == nil {
+		return
+	}
+
+	b, err := json.Marshal(newAuditRecord(ev))
+	if err != nil {
+		log.Printf("[%s] audit record: %v", ev.Addr, err)
+		return
+	}
+	b = append(b, '\n')
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	_, err = s.AuditLog.Write(b)
+	if err != nil {
+		log.Printf("[%s] audit log: %v", ev.Addr, err)
+	}
+}
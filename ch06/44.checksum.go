@@ -0,0 +1,47 @@
+// 보내거나 받은 내용의 SHA-512/256 체크섬
+package tftp
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// 체크섬을 계산할 해시 생성
+// sha512 도구, get 도구와 감사 로그가 같은 해시를 써서 서로 비교할 수 있다
+func NewChecksum() hash.Hash {
+	return sha512.New512_256()
+}
+
+// r을 끝까지 읽어서 sha512 도구와 같은 16진수 체크섬 리턴
+// 파일 전체를 메모리에 올리지 않고 읽으면서 계산
+func Checksum(r io.Reader) (string, error) {
+	h := NewChecksum()
+
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+
+	return checksumString(h), nil
+}
+
+// 전송 내용의 체크섬을 계산할 해시 생성
+// 체크섬은 감사 로그와 OnTransfer로만 알리므로 둘 다 없다면 계산하지 않도록 nil 리턴
+func (s *Server) newChecksum() hash.Hash {
+	if s.AuditLog == nil && s.OnTransfer == nil {
+		return nil
+	}
+
+	return NewChecksum()
+}
+
+// 해시의 현재 값을 16진수 체크섬으로, 해시가 nil이면 빈 문자열
+func checksumString(h hash.Hash) string {
+	if h == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,268 @@
+// 44 감사 로그와 체크섬 테스트하기
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// 감사 로그 한 줄마다 채널로 보내는 AuditLog
+type auditLines chan []byte
+
+func (c auditLines) Write(p []byte) (int, error) {
+	c <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+// 다음 감사 기록을 기다려서 해석
+func nextAudit(t *testing.T, lines auditLines) AuditRecord {
+	t.Helper()
+
+	var rec AuditRecord
+
+	select {
+	case line := <-lines:
+		if !bytes.HasSuffix(line, []byte("\n")) || bytes.Count(line, []byte("\n")) != 1 {
+			t.Fatalf("expected one JSON line; actual %q", line)
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatalf("%v: %q", err, line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("audit record not written")
+	}
+
+	return rec
+}
+
+func checksumOf(t *testing.T, b []byte) string {
+	t.Helper()
+
+	sum, err := Checksum(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sum
+}
+
+func TestChecksum(t *testing.T) {
+	// sha512 도구와 같은 SHA-512/256 값
+	sum := checksumOf(t, []byte("abc"))
+	expected := "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23"
+	if sum != expected {
+		t.Errorf("expected %s; actual %s", expected, sum)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	boot := bytes.Repeat([]byte("boot loader\n"), 300)
+	lines := make(auditLines, 10)
+	uploads := make(chan []byte, 1)
+
+	s := &Server{
+		FS: fstest.MapFS{"pxelinux.0": {Data: boot}},
+		Upload: func(string) (io.WriteCloser, error) {
+			return &chanWriter{done: uploads}, nil
+		},
+		Timeout:  time.Second,
+		AuditLog: lines,
+	}
+	addr := startServer(t, s).String()
+
+	c := Client{Timeout: time.Second, Options: map[string]string{"blksize": "1024"}}
+
+	t.Run("download", func(t *testing.T) {
+		_, err := c.Get(context.Background(), addr, "pxelinux.0", io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := nextAudit(t, lines)
+		if rec.Op != "RRQ" || rec.Filename != "pxelinux.0" || rec.Mode != ModeOctet {
+			t.Errorf("unexpected request %+v", rec)
+		}
+		if rec.Client == "" || rec.Client == addr {
+			t.Errorf("expected client address; actual %q", rec.Client)
+		}
+		if rec.Options["blksize"] != "1024" {
+			t.Errorf("expected negotiated blksize; actual %v", rec.Options)
+		}
+		if rec.Outcome != OutcomeCompleted || rec.Error != "" || rec.ErrCode != nil {
+			t.Errorf("expected completed transfer; actual %+v", rec)
+		}
+		if rec.Bytes != int64(len(boot)) || rec.Blocks != len(boot)/1024+1 {
+			t.Errorf("expected %d bytes in %d blocks; actual %d bytes in %d blocks",
+				len(boot), len(boot)/1024+1, rec.Bytes, rec.Blocks)
+		}
+		if rec.Checksum != checksumOf(t, boot) {
+			t.Errorf("expected checksum of the file; actual %s", rec.Checksum)
+		}
+		if rec.Time.IsZero() || rec.Duration <= 0 {
+			t.Errorf("expected time and duration; actual %v, %v", rec.Time, rec.Duration)
+		}
+	})
+
+	t.Run("netascii", func(t *testing.T) {
+		// 줄바꿈을 변환해서 보내도 체크섬과 bytes 수는 파일 기준
+		c := Client{Timeout: time.Second, Mode: ModeNetASCII}
+		_, err := c.Get(context.Background(), addr, "pxelinux.0", io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := nextAudit(t, lines)
+		if rec.Mode != ModeNetASCII || rec.Options != nil {
+			t.Errorf("expected netascii without options; actual %+v", rec)
+		}
+		if rec.Bytes != int64(len(boot)) || rec.Checksum != checksumOf(t, boot) {
+			t.Errorf("expected checksum of the file; actual %d bytes, %s", rec.Bytes, rec.Checksum)
+		}
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		firmware := bytes.Repeat([]byte{0xfe}, 2*BlockSize)
+		_, err := Client{Timeout: time.Second}.Put(context.Background(), addr, "firmware.bin", bytes.NewReader(firmware))
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-uploads
+
+		rec := nextAudit(t, lines)
+		if rec.Op != "WRQ" || rec.Filename != "firmware.bin" || rec.Outcome != OutcomeCompleted {
+			t.Errorf("unexpected record %+v", rec)
+		}
+		// 블록 크기로 나누어 떨어지면 빈 블록을 하나 더 받는다
+		if rec.Bytes != int64(len(firmware)) || rec.Blocks != 3 {
+			t.Errorf("expected %d bytes in 3 blocks; actual %d bytes in %d blocks", len(firmware), rec.Bytes, rec.Blocks)
+		}
+		if rec.Checksum != checksumOf(t, firmware) {
+			t.Errorf("expected checksum of the upload; actual %s", rec.Checksum)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := c.Get(context.Background(), addr, "missing", io.Discard)
+		if err == nil {
+			t.Fatal("expected file not found")
+		}
+
+		// 파일을 열지 못했다면 체크섬은 남기지 않는다
+		rec := nextAudit(t, lines)
+		if rec.Outcome != OutcomeFailed || !strings.Contains(rec.Error, "missing") {
+			t.Errorf("expected failed transfer; actual %+v", rec)
+		}
+		if rec.Bytes != 0 || rec.Checksum != "" {
+			t.Errorf("expected no checksum; actual %d bytes, %q", rec.Bytes, rec.Checksum)
+		}
+	})
+}
+
+func TestAuditLogOutcomes(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, 3*BlockSize)
+	lines := make(auditLines, 10)
+	s := &Server{Payload: payload, Retries: 2, Timeout: 100 * time.Millisecond, AuditLog: lines}
+	addr := startServer(t, s)
+
+	t.Run("client error", func(t *testing.T) {
+		// 첫 블록을 받고 에러 패킷으로 중단
+		client, peer := stalledDownload(t, addr)
+		errPkt, _ := Err{Error: ErrDiskFull, Message: "disk full"}.MarshalBinary()
+		if _, err := client.WriteTo(errPkt, peer); err != nil {
+			t.Fatal(err)
+		}
+
+		rec := nextAudit(t, lines)
+		if rec.Outcome != OutcomeClientError || rec.ErrCode == nil || *rec.ErrCode != ErrDiskFull {
+			t.Errorf("expected client error %d; actual %+v", ErrDiskFull, rec)
+		}
+		if rec.Error != "received error: disk full" {
+			t.Errorf("expected error message; actual %q", rec.Error)
+		}
+		// 보낸 첫 블록까지의 체크섬
+		if rec.Bytes != BlockSize || rec.Checksum != checksumOf(t, payload[:BlockSize]) {
+			t.Errorf("expected checksum of the first block; actual %d bytes, %s", rec.Bytes, rec.Checksum)
+		}
+	})
+
+	t.Run("exhausted retries", func(t *testing.T) {
+		// 첫 블록을 받고 ACK를 보내지 않는다
+		_, _ = stalledDownload(t, addr)
+
+		rec := nextAudit(t, lines)
+		if rec.Outcome != OutcomeExhausted || rec.ErrCode != nil {
+			t.Errorf("expected exhausted retries; actual %+v", rec)
+		}
+	})
+}
+
+// 블록 크기보다 길게 읽은 bytes 수를 세는 Source
+// 멀티캐스트 세션은 블록을 BlockSize까지만 읽으므로 나머지는 체크섬 계산으로 읽은 것
+type checksumReads struct {
+	*bytes.Reader
+	n atomic.Int64
+}
+
+func (r *checksumReads) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.Reader.ReadAt(p, off)
+	if len(p) > BlockSize {
+		r.n.Add(int64(n))
+	}
+
+	return n, err
+}
+
+func TestAuditLogMulticast(t *testing.T) {
+	payload := bytes.Repeat([]byte("kernel image\n"), 3000)
+
+	for _, audit := range []bool{true, false} {
+		src := &checksumReads{Reader: bytes.NewReader(payload)}
+		lines := make(auditLines, 10)
+		s := &Server{Source: src}
+		if audit {
+			s.AuditLog = lines
+		}
+		m := newMulticastTest(t, s)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := m.client.Get(context.Background(), m.addr.String(), "vmlinuz", io.Discard)
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		// 감사 로그나 OnTransfer가 없다면 체크섬을 계산하지 않는다
+		if !audit {
+			if n := src.n.Load(); n != 0 {
+				t.Errorf("expected no checksum without audit log; actual %d bytes read", n)
+			}
+			continue
+		}
+
+		// 모든 클라이언트의 기록에 같은 체크섬을 남기지만 세션에서 한 번만 계산한다
+		for i := 0; i < 3; i++ {
+			rec := nextAudit(t, lines)
+			if rec.Checksum != checksumOf(t, payload) || rec.Blocks != len(payload)/BlockSize+1 {
+				t.Errorf("expected checksum of the file in %d blocks; actual %+v", len(payload)/BlockSize+1, rec)
+			}
+		}
+		if n := src.n.Load(); n != int64(len(payload)) {
+			t.Errorf("expected checksum computed once from %d bytes; actual %d bytes read", len(payload), n)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// 테스트용 메모리 내 UDP 네트워크
+// 실제 소켓 없이 패킷을 주고받으며 정해진 확률로 패킷을 유실, 중복, 지연, 순서 변경, 손상시킨다
+// 같은 seed라면 어떤 패킷에 무슨 일이 생길지 같은 순서로 정해지므로 결과를 재현할 수 있다
+package lossy
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 네트워크가 패킷에 일으킬 문제들
+// 확률은 0 ~ 1, 모두 0이면 유실 없는 네트워크
+type Config struct {
+	// 패킷 유실 확률
+	Loss float64
+	// 패킷이 두 번 도착할 확률
+	Duplicate float64
+	// 패킷이 ReorderDelay만큼 늦게 도착해서 뒤에 보낸 패킷에 추월당할 확률
+	Reorder float64
+	// 순서를 바꿀 패킷에 더할 지연, 0이면 1ms
+	ReorderDelay time.Duration
+	// 모든 패킷의 지연과 패킷마다 무작위로 더할 최대 지연
+	Delay  time.Duration
+	Jitter time.Duration
+	// 패킷의 1bit를 뒤집을 확률
+	// UDP 체크섬을 통과한 손상이므로 받는 쪽이 직접 걸러내야 한다
+	Corrupt float64
+	// 무작위 선택에 쓸 seed
+	Seed int64
+}
+
+// 네트워크가 패킷에 일으킨 일의 횟수
+type Stats struct {
+	// 소켓이 보낸 패킷 수
+	Sent int
+	// 유실된 패킷 수, 받을 소켓이 없거나 큐가 가득 차서 버린 패킷도 포함
+	Dropped    int
+	Duplicated int
+	Reordered  int
+	Corrupted  int
+}
+
+// 메모리 내 네트워크
+type Network struct {
+	cfg Config
+
+	mu    sync.Mutex
+	conns map[string]*conn
+	// 마지막으로 할당한 포트 번호
+	port  int
+	rand  *rand.Rand
+	stats Stats
+}
+
+// cfg대로 패킷을 다루는 메모리 내 네트워크 생성
+func NewNetwork(cfg Config) *Network {
+	if cfg.ReorderDelay == 0 {
+		cfg.ReorderDelay = time.Millisecond
+	}
+
+	return &Network{
+		cfg:   cfg,
+		conns: make(map[string]*conn),
+		port:  10000,
+		rand:  rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// net.ListenPacket과 같은 모양의 함수
+// 호스트와 관계없이 127.0.0.1에 소켓을 만들고
+// 포트가 0이거나 없다면 새 포트 할당
+func (n *Network) ListenPacket(network, address string) (net.PacketConn, error) {
+	port := 0
+	if _, p, err := net.SplitHostPort(address); err == nil && p != "" {
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			return nil, &net.OpError{Op: "listen", Net: network, Err: err}
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if port == 0 {
+		// 사용 중인 포트는 건너뛰기
+		for {
+			n.port++
+			if _, ok := n.conns[addrString(n.port)]; !ok {
+				break
+			}
+		}
+		port = n.port
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	if _, ok := n.conns[addr.String()]; ok {
+		return nil, &net.OpError{Op: "listen", Net: network, Addr: addr, Err: errors.New("address already in use")}
+	}
+
+	c := &conn{
+		network: n,
+		addr:    addr,
+		in:      make(chan packet, 1024),
+		closed:  make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	n.conns[addr.String()] = c
+
+	return c, nil
+}
+
+// 서로를 상대로 패킷을 주고받을 소켓 한 쌍
+func (n *Network) Pipe() (net.PacketConn, net.PacketConn, error) {
+	a, err := n.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := n.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		_ = a.Close()
+		return nil, nil, err
+	}
+
+	return a, b, nil
+}
+
+// 지금까지 네트워크가 패킷에 일으킨 일의 횟수
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.stats
+}
+
+func addrString(port int) string {
+	return (&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}).String()
+}
+
+// 메모리 내 패킷
+type packet struct {
+	from net.Addr
+	data []byte
+}
+
+// from에서 to로 보낸 패킷에 무슨 일이 생길지 정하고 전달
+func (n *Network) send(from, to net.Addr, b []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.stats.Sent++
+
+	if n.rand.Float64() < n.cfg.Loss {
+		n.stats.Dropped++
+		return
+	}
+
+	copies := 1
+	if n.rand.Float64() < n.cfg.Duplicate {
+		n.stats.Duplicated++
+		copies++
+	}
+
+	for i := 0; i < copies; i++ {
+		p := packet{from: from, data: append([]byte(nil), b...)}
+
+		if len(p.data) > 0 && n.rand.Float64() < n.cfg.Corrupt {
+			n.stats.Corrupted++
+			p.data[n.rand.Intn(len(p.data))] ^= 1 << n.rand.Intn(8)
+		}
+
+		delay := n.cfg.Delay
+		if n.cfg.Jitter > 0 {
+			delay += time.Duration(n.rand.Int63n(int64(n.cfg.Jitter)))
+		}
+		if n.rand.Float64() < n.cfg.Reorder {
+			n.stats.Reordered++
+			delay += n.cfg.ReorderDelay
+		}
+
+		// 지연이 없다면 보낸 순서대로 바로 도착
+		if delay == 0 {
+			n.deliver(to, p)
+			continue
+		}
+
+		time.AfterFunc(delay, func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			n.deliver(to, p)
+		})
+	}
+}
+
+// 받는 쪽 큐에 패킷 넣기, n.mu를 잡고 호출해야 한다
+// 받을 소켓이 없거나 큐가 가득 차면 버린다
+func (n *Network) deliver(to net.Addr, p packet) {
+	dst, ok := n.conns[to.String()]
+	if !ok {
+		n.stats.Dropped++
+		return
+	}
+
+	select {
+	case dst.in <- p:
+	default:
+		n.stats.Dropped++
+	}
+}
+
+// 메모리 내 net.PacketConn
+type conn struct {
+	network *Network
+	addr    *net.UDPAddr
+	in      chan packet
+	closed  chan struct{}
+	once    sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+	// 데드라인이 바뀌면 닫아서 기다리던 ReadFrom을 깨운다
+	changed chan struct{}
+}
+
+func (c *conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.deadline, c.changed
+		c.mu.Unlock()
+
+		// 데드라인이 있다면 남은 시간만큼 타이머 설정
+		var (
+			timeout <-chan time.Time
+			timer   *time.Timer
+		)
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+
+		select {
+		case pkt := <-c.in:
+			stopTimer(timer)
+			// UDP처럼 버퍼보다 큰 패킷은 잘린다
+			return copy(p, pkt.data), pkt.from, nil
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-c.closed:
+			stopTimer(timer)
+			return 0, nil, net.ErrClosed
+		// 기다리는 동안 데드라인이 바뀌었다면 새 데드라인으로 다시 기다리기
+		case <-changed:
+			stopTimer(timer)
+		}
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
+
+func (c *conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	c.network.send(c.addr, addr, p)
+
+	return len(p), nil
+}
+
+func (c *conn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+
+		c.network.mu.Lock()
+		delete(c.network.conns, c.addr.String())
+		c.network.mu.Unlock()
+	})
+
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr { return c.addr }
+
+func (c *conn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,201 @@
+// 38 손실 네트워크 테스트하기
+package lossy
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// a에서 b로 번호를 붙인 패킷 count개 보내기
+func sendNumbered(t *testing.T, a, b net.PacketConn, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		_, err := a.WriteTo([]byte{byte(i >> 8), byte(i)}, b.LocalAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// wait 동안 더 오는 패킷이 없을 때까지 받은 패킷들
+func receiveAll(t *testing.T, c net.PacketConn, wait time.Duration) [][]byte {
+	t.Helper()
+
+	var received [][]byte
+	for {
+		_ = c.SetReadDeadline(time.Now().Add(wait))
+
+		buf := make([]byte, 64)
+		n, _, err := c.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return received
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		received = append(received, buf[:n])
+	}
+}
+
+// cfg의 네트워크로 count개를 보내고 받은 패킷과 통계 리턴
+func transmit(t *testing.T, cfg Config, count int) ([][]byte, Stats) {
+	t.Helper()
+
+	n := NewNetwork(cfg)
+	a, b, err := n.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = a.Close(), b.Close() }()
+
+	sendNumbered(t, a, b, count)
+
+	return receiveAll(t, b, 50*time.Millisecond), n.Stats()
+}
+
+func TestLoss(t *testing.T) {
+	received, stats := transmit(t, Config{Loss: 0.2, Seed: 1}, 1000)
+
+	if stats.Sent != 1000 || stats.Dropped < 150 || stats.Dropped > 250 {
+		t.Errorf("expected about 200 of 1000 packets dropped; actual %+v", stats)
+	}
+	if len(received) != stats.Sent-stats.Dropped {
+		t.Errorf("expected %d packets; actual %d", stats.Sent-stats.Dropped, len(received))
+	}
+
+	// 같은 seed라면 같은 패킷이 유실된다
+	again, _ := transmit(t, Config{Loss: 0.2, Seed: 1}, 1000)
+	if len(again) != len(received) {
+		t.Fatalf("expected %d packets with the same seed; actual %d", len(received), len(again))
+	}
+	for i := range received {
+		if !bytes.Equal(received[i], again[i]) {
+			t.Fatalf("packet %d: expected %v; actual %v", i, received[i], again[i])
+		}
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	received, stats := transmit(t, Config{Duplicate: 1}, 10)
+
+	if stats.Duplicated != 10 || len(received) != 20 {
+		t.Fatalf("expected 20 packets; actual %d, %+v", len(received), stats)
+	}
+
+	for i := 0; i < 10; i++ {
+		if !bytes.Equal(received[2*i], received[2*i+1]) {
+			t.Errorf("expected duplicate of %v; actual %v", received[2*i], received[2*i+1])
+		}
+	}
+}
+
+func TestReorder(t *testing.T) {
+	received, stats := transmit(t, Config{Reorder: 0.3, ReorderDelay: 10 * time.Millisecond, Seed: 1}, 100)
+
+	if len(received) != 100 {
+		t.Fatalf("expected 100 packets; actual %d", len(received))
+	}
+
+	// 늦게 도착한 패킷보다 뒤에 보낸 패킷이 먼저 도착해야 한다
+	overtaken := 0
+	for i := 1; i < len(received); i++ {
+		if bytes.Compare(received[i-1], received[i]) > 0 {
+			overtaken++
+		}
+	}
+	if stats.Reordered == 0 || overtaken == 0 {
+		t.Errorf("expected reordered packets; actual %d overtaken, %+v", overtaken, stats)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	received, stats := transmit(t, Config{Corrupt: 1, Seed: 1}, 50)
+
+	if stats.Corrupted != 50 || len(received) != 50 {
+		t.Fatalf("expected 50 corrupted packets; actual %d, %+v", len(received), stats)
+	}
+
+	// 패킷마다 1bit만 뒤집힌다
+	for i, p := range received {
+		diff := bits.OnesCount8(p[0]^byte(i>>8)) + bits.OnesCount8(p[1]^byte(i))
+		if diff != 1 {
+			t.Errorf("packet %d: expected 1 flipped bit; actual %d", i, diff)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	n := NewNetwork(Config{Delay: 30 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	a, b, err := n.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = a.Close(), b.Close() }()
+
+	start := time.Now()
+	sendNumbered(t, a, b, 1)
+
+	_ = b.SetReadDeadline(time.Now().Add(time.Second))
+	_, from, err := b.ReadFrom(make([]byte, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("expected at least 30ms delay; actual %s", elapsed)
+	}
+	if from.String() != a.LocalAddr().String() {
+		t.Errorf("expected packet from %s; actual %s", a.LocalAddr(), from)
+	}
+}
+
+func TestConn(t *testing.T) {
+	n := NewNetwork(Config{})
+
+	c, err := n.ListenPacket("udp", "127.0.0.1:69")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := c.LocalAddr().String(); addr != "127.0.0.1:69" {
+		t.Errorf("expected 127.0.0.1:69; actual %s", addr)
+	}
+
+	// 사용 중인 포트에는 소켓을 만들 수 없다
+	if _, err = n.ListenPacket("udp", "127.0.0.1:69"); err == nil {
+		t.Error("expected address in use error")
+	}
+
+	_ = c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, _, err = c.ReadFrom(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected deadline exceeded; actual %v", err)
+	}
+
+	// 기다리는 중에 닫으면 바로 깨어난다
+	_ = c.SetReadDeadline(time.Time{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = c.Close()
+	}()
+	if _, _, err = c.ReadFrom(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected closed error; actual %v", err)
+	}
+
+	// 닫은 소켓으로 보내는 패킷은 유실
+	d, err := n.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+
+	_, _ = d.WriteTo([]byte("x"), c.LocalAddr())
+	if stats := n.Stats(); stats.Sent != 1 || stats.Dropped != 1 {
+		t.Errorf("expected 1 dropped packet; actual %+v", stats)
+	}
+}
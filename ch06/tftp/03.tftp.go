@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"tftp"
 )
 
@@ -11,23 +20,209 @@ var (
 	// 플래그 설정 방법 -a
 	// 기본값
 	// 플래그 설명
-	address = flag.String("a", "127.0.0.1:69", "listen address")
+	address = flag.String("a", "127.0.0.1:69", "comma-separated listen addresses (e.g. 0.0.0.0:69,[fe80::1%eth0]:69)")
 	payload = flag.String("p", "payload.svg", "file to serve to clients")
+	root    = flag.String("d", "", "directory to serve files from (overrides -p)")
+	cache   = flag.Int64("c", 0, "bytes of files from -d to cache in memory")
+	upload  = flag.String("u", "", "directory to store uploaded files")
+	rules   = flag.String("r", "", "access rules file (reloaded when changed or on SIGHUP)")
+	metrics = flag.String("m", "", "address to serve Prometheus metrics on /metrics")
+	group   = flag.String("g", "", "multicast group address for clients requesting the multicast option")
+	iface   = flag.String("i", "", "interface to send multicast packets on")
+	runAs   = flag.String("U", "", "user to run as after binding the listen addresses")
+	chroot  = flag.Bool("C", false, "chroot to the -d directory after binding, requires -U (-u and -r must be inside it)")
+	audit   = flag.String("l", "", "file to append a JSON-lines audit record to for each transfer")
 )
 
+// chroot한 뒤 name을 가리킬 경로
+// dir 밖의 경로라면 chroot한 뒤에는 쓸 수 없으므로 에러
+func chrootPath(dir, name string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	absName, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(absDir, absName)
+	if err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+		return "", fmt.Errorf("%s is outside %s", name, dir)
+	}
+
+	return filepath.Join("/", rel), nil
+}
+
 func main() {
 	// 인수로 받은 플래그 파싱
 	flag.Parse()
 
-	// ioutil이 deprecated되어서 os를 사용해야한다
-	// 받은 payload주소로부터 파일을 읽어서 p변수에 저장
-	p, err := os.ReadFile(*payload)
+	// 69번 포트는 root 권한이 필요하므로 권한을 낮추기 전에 모든 리스너를 연다
+	conns, err := tftp.Listen(strings.Split(*address, ",")...)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Server 인스턴스에 읽은 파일 저장
-	s := tftp.Server{Payload: p}
-	// udp 서버에 위에서 생성한 s를 가지고 연결
-	log.Fatal(s.ListenAndServe(*address))
+	var metricsListener net.Listener
+	if *metrics != "" {
+		metricsListener, err = net.Listen("tcp", *metrics)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 감사 로그는 권한을 낮춘 사용자가 지우거나 고칠 수 없도록 먼저 열어둔다
+	// 덧붙이기만 할 수 있게 O_APPEND로 연다
+	var auditLog *os.File
+	if *audit != "" {
+		auditLog, err = os.OpenFile(*audit, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { _ = auditLog.Close() }()
+	}
+
+	// chroot한 뒤에는 -d 디렉터리가 /가 되므로 경로를 미리 바꿔둔다
+	if *chroot {
+		if *root == "" {
+			log.Fatal("-C requires -d")
+		}
+		// root로 남으면 chroot 밖으로 나갈 수 있으므로 사용자도 바꿔야 한다
+		if *runAs == "" {
+			log.Fatal("-C requires -U")
+		}
+
+		for _, path := range []*string{upload, rules} {
+			if *path == "" {
+				continue
+			}
+
+			*path, err = chrootPath(*root, *path)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// 사용자를 바꾸거나 chroot하지 못했다면 root 권한으로 계속하지 않고 종료
+	if *runAs != "" || *chroot {
+		dir := ""
+		if *chroot {
+			dir = *root
+			*root = "/"
+		}
+
+		err = tftp.DropPrivileges(*runAs, dir)
+		if err != nil {
+			log.Fatalf("dropping privileges: %v", err)
+		}
+		log.Printf("running as uid %d, gid %d", os.Getuid(), os.Getgid())
+	}
+
+	var s tftp.Server
+
+	if *root != "" {
+		// 디렉터리가 주어졌다면 요청한 파일명으로 디렉터리에서 파일을 찾아 보내기
+		s.FS = os.DirFS(*root)
+
+		// 캐시 크기가 주어졌다면 자주 요청하는 파일은 메모리에서 보내기
+		if *cache > 0 {
+			s.Cache = tftp.NewFileCache(*cache)
+		}
+	} else {
+		// 파일을 메모리에 전부 읽지 않고 열어만 두고
+		// 모든 전송이 필요한 블록을 위치로 읽는다
+		f, err := os.Open(*payload)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+
+		s.Source = f
+	}
+
+	// 전송마다 누가 무엇을 주고받았는지와 그 체크섬 기록
+	if auditLog != nil {
+		s.AuditLog = auditLog
+	}
+
+	// 업로드 디렉터리가 주어졌다면 쓰기 요청 허용
+	if *upload != "" {
+		s.Upload = tftp.UploadDir(*upload)
+	}
+
+	// 접근 제어 규칙 파일이 주어졌다면 규칙에 맞는 요청만 허용
+	if *rules != "" {
+		access, err := tftp.LoadAccessFile(*rules)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Access = access
+
+		// SIGHUP을 받으면 바로 다시 읽기
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := access.Reload(); err != nil {
+					log.Printf("reloading access rules: %v", err)
+					continue
+				}
+				log.Print("reloaded access rules")
+			}
+		}()
+	}
+
+	// 멀티캐스트 그룹이 주어졌다면 multicast 옵션 수락
+	if *group != "" {
+		addr, err := net.ResolveUDPAddr("udp", *group)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Multicast = addr
+
+		if *iface != "" {
+			ifi, err := net.InterfaceByName(*iface)
+			if err != nil {
+				log.Fatal(err)
+			}
+			s.MulticastInterface = ifi
+		}
+	}
+
+	// 통계를 볼 수 있는 HTTP 서버 띄우기
+	if metricsListener != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.MetricsHandler())
+
+		go func() {
+			log.Printf("Serving metrics on %s ...", metricsListener.Addr())
+			log.Print(http.Serve(metricsListener, mux))
+		}()
+	}
+
+	// Ctrl+C를 받으면 새 요청을 받지 않고 진행 중인 전송을 기다렸다가 종료
+	// 기다리는 중에 다시 Ctrl+C를 받으면 전송을 중단
+	go func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		<-ctx.Done()
+		stop()
+
+		log.Print("shutting down ...")
+
+		ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+
+	// 먼저 연 모든 리스너에서 위에서 생성한 s로 요청 받기
+	err = s.ServeAll(context.Background(), conns...)
+	if !errors.Is(err, tftp.ErrServerClosed) {
+		log.Fatal(err)
+	}
 }
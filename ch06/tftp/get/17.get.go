@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"tftp"
+	"time"
+)
+
+var (
+	address = flag.String("a", "127.0.0.1:69", "server address")
+	output  = flag.String("o", "", "output file (default: base name of the requested file, - for stdout)")
+	sum     = flag.Bool("s", false, "print the SHA-512/256 checksum of the downloaded file")
+	blksize = flag.Int("b", 0, "block size to request (blksize option)")
+	window  = flag.Int("w", 0, "window size to request (windowsize option)")
+	timeout = flag.Duration("t", 6*time.Second, "time to wait for each response")
+	mode    = flag.String("m", tftp.ModeOctet, "transfer mode (octet or netascii)")
+	mcast   = flag.Bool("g", false, "request the multicast option")
+	iface   = flag.String("i", "", "interface to join the multicast group on")
+)
+
+func init() {
+	// 사용법에 받을 파일명 인수 표시
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	// 받을 파일명은 플래그가 아닌 인수 하나
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	filename := flag.Arg(0)
+
+	c := tftp.Client{Timeout: *timeout, Mode: *mode}
+
+	// 0이 아닌 값만 옵션으로 요청
+	options := make(map[string]string)
+	if *blksize > 0 {
+		options["blksize"] = strconv.Itoa(*blksize)
+	}
+	if *window > 0 {
+		options["windowsize"] = strconv.Itoa(*window)
+	}
+	if *mcast {
+		options["multicast"] = ""
+	}
+	if len(options) > 0 {
+		c.Options = options
+	}
+
+	if *iface != "" {
+		ifi, err := net.InterfaceByName(*iface)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.MulticastInterface = ifi
+	}
+
+	// 출력 파일을 지정하지 않았다면 요청한 파일명에서 디렉터리를 뺀 이름 사용
+	name := *output
+	if name == "" {
+		name = path.Base(filename)
+	}
+
+	var w io.Writer = os.Stdout
+	if name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+
+	// 받으면서 체크섬도 같이 계산
+	var h hash.Hash
+	if *sum {
+		h = tftp.NewChecksum()
+		w = io.MultiWriter(w, h)
+	}
+
+	// Ctrl+C로 전송 중단
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	n, err := c.Get(ctx, *address, filename, w)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("received %d bytes", n)
+
+	// sha512 도구와 같은 형식으로 출력
+	if h != nil {
+		fmt.Printf("%x %s\n", h.Sum(nil), name)
+	}
+}
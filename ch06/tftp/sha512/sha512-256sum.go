@@ -1,10 +1,10 @@
 package main
 
 import (
-	"crypto/sha512"
 	"flag"
 	"fmt"
 	"os"
+	"tftp"
 )
 
 func init() {
@@ -27,13 +27,19 @@ func main() {
 }
 
 func checksum(file string) string {
-	// 파일을 바이너리로 읽어서
-	b, err := os.ReadFile(file)
+	// 파일을 바이너리로 열어서
+	f, err := os.Open(file)
 	if err != nil {
 		return err.Error()
 	}
+	defer func() { _ = f.Close() }()
 
-	// sha512체크섬 리턴
+	// 서버 감사 로그와 같은 sha512체크섬 리턴
 	// 따로 콘솔(stdout)로 나가진 않음
-	return fmt.Sprintf("%x", sha512.Sum512_256(b))
+	sum, err := tftp.Checksum(f)
+	if err != nil {
+		return err.Error()
+	}
+
+	return sum
 }